@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// aiffCommon is the COMM chunk of an AIFF file. The sample rate is an
+// 80-bit IEEE 754 extended precision number.
+type aiffCommon struct {
+	NumChannels     int16
+	NumSampleFrames uint32
+	SampleSize      int16
+	SampleRate      [10]byte
+}
+
+// writeAIFF writes data, as encoded by encodeBigEndianPCM, as an AIFF
+// file. As in any IFF file, a chunk of odd length is
+// followed by a pad byte, which FormSize counts and SsndSize does not.
+func writeAIFF(w io.Writer, format pcmFormat, data []byte) error {
+	dataSize := uint32(len(data))
+	pad := dataSize % 2
+	header := struct {
+		FormID    [4]byte
+		FormSize  uint32
+		FormType  [4]byte
+		CommID    [4]byte
+		CommSize  uint32
+		Comm      aiffCommon
+		SsndID    [4]byte
+		SsndSize  uint32
+		Offset    uint32
+		BlockSize uint32
+	}{
+		FormID:   [4]byte{'F', 'O', 'R', 'M'},
+		FormSize: 4 + 26 + 16 + dataSize + pad,
+		FormType: [4]byte{'A', 'I', 'F', 'F'},
+		CommID:   [4]byte{'C', 'O', 'M', 'M'},
+		CommSize: 18,
+		Comm: aiffCommon{
+			NumChannels:     int16(format.channels),
+			NumSampleFrames: uint32(len(data) / format.blockAlign()),
+			SampleSize:      int16(format.bitDepth),
+			SampleRate:      extendedFloat(uint64(format.sampleRate)),
+		},
+		SsndID:   [4]byte{'S', 'S', 'N', 'D'},
+		SsndSize: 8 + dataSize,
+	}
+
+	err := binary.Write(w, binary.BigEndian, header)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	if err == nil && pad == 1 {
+		_, err = w.Write([]byte{0})
+	}
+	return err
+}
+
+// extendedFloat encodes a positive whole number as an 80-bit extended
+// precision float.
+func extendedFloat(v uint64) [10]byte {
+	var b [10]byte
+	if v == 0 {
+		return b
+	}
+
+	shift := bits.LeadingZeros64(v)
+	exponent := uint16(16383 + 63 - shift)
+	binary.BigEndian.PutUint16(b[0:], exponent&math.MaxInt16)
+	binary.BigEndian.PutUint64(b[2:], v<<shift)
+	return b
+}
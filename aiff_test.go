@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestAIFFPadsOddData(t *testing.T) {
+	var out bytes.Buffer
+	err := writeAIFF(&out, pcmFormat{channels: 1, sampleRate: 8000, bitDepth: 8}, encodeBigEndianPCM([]int16{1000, -1000, 0}, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := out.Bytes()
+	if len(file)%2 != 0 {
+		t.Errorf("file is %d bytes, want an even length", len(file))
+	}
+	if size := binary.BigEndian.Uint32(file[4:]); int(size) != len(file)-8 {
+		t.Errorf("FORM size %d, want %d", size, len(file)-8)
+	}
+	// The SSND chunk follows the 12-byte FORM header and the 26-byte COMM
+	// chunk; its size covers the offset, block size and samples, not the
+	// pad byte.
+	if size := binary.BigEndian.Uint32(file[12+26+4:]); size != 8+3 {
+		t.Errorf("SSND size %d, want %d", size, 8+3)
+	}
+}
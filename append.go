@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// appendFile adds rec to the end of the WAV file at path, creating it if
+// there is none. The file's audio format has to match the recording's, or
+// with --convert-on-append the recording is converted to it; joining
+// audio of different formats would leave a file that plays back wrong.
+// Only the audio is carried over: chunks such as cue points and bext are
+// dropped, since their positions and times no longer hold.
+func appendFile(path string, rec *recording, cfg config) error {
+	existing, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return writeFile(path, rec, cfg)
+	}
+	if err != nil {
+		return err
+	}
+	header, data, err := readWAV(bytes.NewReader(existing))
+	if err != nil {
+		return fmt.Errorf("cannot append to %s: %w", path, err)
+	}
+
+	format := rec.format
+	format.bitDepth = cfg.bitDepth
+	if mismatch := formatMismatch(header, format); mismatch != "" {
+		if !cfg.convertOnAppend {
+			return fmt.Errorf("cannot append to %s: %s (see --convert-on-append)", path, mismatch)
+		}
+		rec, err = convertForAppend(rec, header)
+		if err != nil {
+			return fmt.Errorf("cannot append to %s: %w", path, err)
+		}
+		cfg.bitDepth = int(header.BitsPerSample)
+		format = rec.format
+		format.bitDepth = cfg.bitDepth
+	}
+
+	var rendered bytes.Buffer
+	cfg.format = "wav"
+	err = writeOutput(&rendered, path, rec, cfg)
+	if err != nil {
+		return err
+	}
+	_, added, err := readWAV(&rendered)
+	if err != nil {
+		return err
+	}
+
+	// Written aside and renamed over the original, so that a failure
+	// part way leaves the existing audio as it was.
+	format.extensible = cfg.wavExtensible || format.channels > 2
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = writeWAV(f, format, append(data, added...))
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// formatMismatch describes each way the audio in header differs from
+// format, or returns "" if they match.
+func formatMismatch(header wavHeader, format pcmFormat) string {
+	var diffs []string
+	if int(header.NumChannels) != format.channels {
+		diffs = append(diffs, fmt.Sprintf("the file has %d channel(s), the recording %d", header.NumChannels, format.channels))
+	}
+	if int(header.SampleRate) != format.sampleRate {
+		diffs = append(diffs, fmt.Sprintf("the file is at %d Hz, the recording at %d Hz", header.SampleRate, format.sampleRate))
+	}
+	if int(header.BitsPerSample) != format.bitDepth {
+		diffs = append(diffs, fmt.Sprintf("the file has %d-bit samples, the recording %d-bit", header.BitsPerSample, format.bitDepth))
+	} else if format.isFloat() && header.AudioFormat == wavFormatPCM {
+		diffs = append(diffs, "the file has 32-bit integer samples, the recording floats")
+	}
+	return strings.Join(diffs, "; ")
+}
+
+// convertForAppend returns a copy of rec at the channel count and rate of
+// header, for --convert-on-append. Its markers are dropped, as resampling
+// moves them.
+func convertForAppend(rec *recording, header wavHeader) (*recording, error) {
+	switch header.BitsPerSample {
+	case 8, 16:
+	case 32:
+		if header.AudioFormat == wavFormatPCM {
+			return nil, errors.New("32-bit integer samples cannot be written")
+		}
+	default:
+		return nil, fmt.Errorf("%d-bit samples cannot be written", header.BitsPerSample)
+	}
+
+	channels, rate := int(header.NumChannels), int(header.SampleRate)
+	samples := remixChannels(decodeSamples(rec.audio.Bytes()), rec.format.channels, channels)
+	if rate != rec.format.sampleRate {
+		samples = newResampler(rec.format.sampleRate, rate, channels).process(samples)
+	}
+
+	converted := *rec
+	converted.audio = bytes.NewBuffer(encodeSamples(samples))
+	converted.format.channels = channels
+	converted.format.sampleRate = rate
+	converted.markers, converted.bookmarks, converted.overflows = nil, nil, nil
+	return &converted, nil
+}
+
+// remixChannels converts interleaved samples from one channel count to
+// another: down to mono by averaging, otherwise by repeating the source
+// channels in order.
+func remixChannels(samples []int16, from, to int) []int16 {
+	if from == to {
+		return samples
+	}
+	frames := len(samples) / from
+	out := make([]int16, frames*to)
+	for f := range frames {
+		frame := samples[f*from : (f+1)*from]
+		for c := range to {
+			if to == 1 {
+				sum := 0.0
+				for _, s := range frame {
+					sum += float64(s)
+				}
+				out[f] = clampSample(sum / float64(from))
+			} else {
+				out[f*to+c] = frame[c%from]
+			}
+		}
+	}
+	return out
+}
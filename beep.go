@@ -0,0 +1,30 @@
+package main
+
+import "time"
+
+// beepSearch is how far into a recording --remove-beep looks for the start
+// beep. It only leaks in through output and input latency, so it can only
+// be near the start.
+const beepSearch = time.Second
+
+// beepMatch is the normalised correlation above which the beep counts as
+// heard.
+const beepMatch = 0.3
+
+// removeBeep finds the start beep near the beginning of samples by
+// cross-correlation and silences it, if it is there.
+func removeBeep(samples []int16, channels int) {
+	beep := generateBeep(beepDuration)
+	frames := min(len(samples)/channels, int(beepSearch.Seconds()*float64(sampleRate))+len(beep))
+	mix := make([]float64, frames)
+	for f := range mix {
+		for _, v := range samples[f*channels : (f+1)*channels] {
+			mix[f] += float64(v) / fullScale / float64(channels)
+		}
+	}
+
+	lag, score := findSignal(mix, beep)
+	if score >= beepMatch {
+		clear(samples[lag*channels : min(lag+len(beep), frames)*channels])
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// cafDescription is the desc chunk of a CAF file, describing big-endian
+// linear PCM.
+type cafDescription struct {
+	SampleRate       float64
+	FormatID         [4]byte
+	FormatFlags      uint32
+	BytesPerPacket   uint32
+	FramesPerPacket  uint32
+	ChannelsPerFrame uint32
+	BitsPerChannel   uint32
+}
+
+// writeCAF writes data, as encoded by encodeBigEndianPCM, as a Core Audio
+// Format file. Chunk sizes are 64 bit, so unlike WAV the file is not
+// limited to 4 GiB.
+func writeCAF(w io.Writer, format pcmFormat, data []byte) error {
+	dataSize := int64(len(data))
+	header := struct {
+		FileType    [4]byte
+		FileVersion uint16
+		FileFlags   uint16
+		DescID      [4]byte
+		DescSize    int64
+		Desc        cafDescription
+		DataID      [4]byte
+		DataSize    int64
+		EditCount   uint32
+	}{
+		FileType:    [4]byte{'c', 'a', 'f', 'f'},
+		FileVersion: 1,
+		DescID:      [4]byte{'d', 'e', 's', 'c'},
+		DescSize:    32,
+		Desc: cafDescription{
+			SampleRate:       float64(format.sampleRate),
+			FormatID:         [4]byte{'l', 'p', 'c', 'm'},
+			BytesPerPacket:   uint32(format.blockAlign()),
+			FramesPerPacket:  1,
+			ChannelsPerFrame: uint32(format.channels),
+			BitsPerChannel:   uint32(format.bitDepth),
+		},
+		DataID:   [4]byte{'d', 'a', 't', 'a'},
+		DataSize: 4 + dataSize,
+	}
+
+	err := binary.Write(w, binary.BigEndian, header)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// calibration is what the detector measures over its first window, as
+// written by --save-calibration and read back by --load-calibration.
+type calibration struct {
+	Saved     time.Time `json:"saved"`
+	Floor     float64   `json:"floor"`               // ambient level, normalised
+	Threshold float64   `json:"threshold,omitempty"` // with --auto-threshold-percentile
+}
+
+func saveCalibration(path string, c calibration) error {
+	c.Saved = time.Now()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func loadCalibration(path string) (calibration, error) {
+	var c calibration
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return c, fmt.Errorf("%s: %w", path, err)
+	}
+	if c.Floor <= 0 {
+		return c, fmt.Errorf("%s: no noise floor saved", path)
+	}
+	return c, nil
+}
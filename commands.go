@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+var subcommands = []string{"record", "devices", "repair", "test", "vad-bench"}
+
+// subcommand splits the subcommand name off args, defaulting to record so
+// that plain `raus [flags]` keeps working.
+func subcommand(args []string) (string, []string) {
+	if len(args) > 0 && slices.Contains(subcommands, args[0]) {
+		return args[0], args[1:]
+	}
+	return "record", args
+}
+
+// noArgs exits with a usage error if anything but flags was given, such
+// as a misspelt subcommand, which would otherwise start a recording.
+func noArgs() {
+	if flag.NArg() > 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command or argument %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage:\n")
+	fmt.Fprintf(out, "  raus [record] [flags]  record until silence (the default)\n")
+	fmt.Fprintf(out, "  raus devices           list the devices of each host API\n")
+	fmt.Fprintf(out, "  raus repair FILE...    fix the header of WAV files left by an interrupted recording\n")
+	fmt.Fprintf(out, "  raus test [flags]      capture a few seconds and report the input level\n")
+	fmt.Fprintf(out, "  raus vad-bench [flags] FIXTURE.json\n")
+	fmt.Fprintf(out, "                         score speech detection against labelled audio\n")
+	fmt.Fprintf(out, "\nFlags for record, test and vad-bench:\n")
+	flag.PrintDefaults()
+}
+
+// initAudio and terminateAudio bracket a whole session: every recording,
+// beep and take in it shares the one initialisation. Only a device error
+// under --keep-going-on-error restarts it.
+func initAudio() {
+	err := portaudio.Initialize()
+	if err != nil {
+		log.Fatalf("could not initialise audio: %v", err)
+	}
+}
+
+func terminateAudio() {
+	err := portaudio.Terminate()
+	if err != nil {
+		log.Printf("could not shut down audio cleanly: %v", err)
+	}
+}
+
+func runDevices(args []string) {
+	fs := flag.NewFlagSet("devices", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: raus devices\n")
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	initAudio()
+	defer terminateAudio()
+	err := listDevices()
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func runRepair(args []string) {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: raus repair FILE...\n")
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	for _, path := range fs.Args() {
+		n, err := repairWAV(path)
+		if err != nil {
+			fatal(fmt.Errorf("%s: %w", path, err))
+		}
+		fmt.Fprintf(os.Stderr, "Repaired %s: %d bytes of audio\n", path, n)
+	}
+}
+
+// testSeconds is how long the test subcommand listens for.
+const testSeconds = 3
+
+// runTest captures a few seconds from the configured input, without beeps
+// or speech detection, and reports how loud it was.
+func runTest(args []string) {
+	cfg := parseFlags(args)
+	noArgs()
+
+	initAudio()
+	defer terminateAudio()
+
+	fmt.Fprintf(os.Stderr, "Listening to %s for %ds...\n", inputName(cfg), testSeconds)
+	src, err := openInput(cfg)
+	if err != nil {
+		fatal(err)
+	}
+	cfg.samples = testSeconds * src.Rate()
+	rec, err := recordAudioWithDynamicNoiseFloor(src, cfg, nil, nil)
+	if err != nil {
+		fatal(err)
+	}
+
+	samples := decodeSamples(rec.audio.Bytes())
+	levels := make([]float64, len(samples))
+	for i, s := range samples {
+		levels[i] = amplitude(s)
+	}
+	peak := peakLevel(samples)
+	fmt.Printf("peak:    %.4f (%.1f dBFS)\n", peak, toDBFS(peak))
+	fmt.Printf("average: %.4f\n", calculateAverage(levels))
+	fmt.Printf("clipped: %d samples\n", countClipped(samples))
+	if float64(countNonzero(samples)) < float64(len(samples))*minNonzeroFraction {
+		fatal(errSilentInput)
+	}
+}
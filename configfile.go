@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configFile holds the settings read from the config file: flag values
+// given before any section, which apply to every run, and the
+// [profile.NAME] blocks that --profile selects between.
+type configFile struct {
+	path     string
+	defaults []setting
+	profiles map[string][]setting
+}
+
+// setting is one "name = value" line of the config file, naming a flag
+// without its dashes.
+type setting struct {
+	name, value string
+	line        int
+}
+
+// defaultConfigPath returns where the config file is looked for when
+// --config is not given.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "raus", "config")
+}
+
+// loadConfigFile reads the config file at path. A missing file is only an
+// error if it was asked for explicitly.
+func loadConfigFile(path string, explicit bool) (*configFile, error) {
+	c := &configFile{path: path, profiles: map[string][]setting{}}
+	if path == "" {
+		return c, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	profile := "" // the section being read, or "" before the first
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name, ok := strings.CutPrefix(line[1:len(line)-1], "profile.")
+			if !ok || name == "" {
+				return nil, fmt.Errorf("%s:%d: unknown section %s, want [profile.NAME]", path, n, line)
+			}
+			profile = name
+			c.profiles[profile] = c.profiles[profile] // an empty block still counts
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want name = value", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		s := setting{strings.TrimSpace(name), value, n}
+		if profile == "" {
+			c.defaults = append(c.defaults, s)
+		} else {
+			c.profiles[profile] = append(c.profiles[profile], s)
+		}
+	}
+	return c, scanner.Err()
+}
+
+// apply sets the flags named by settings, except those in skip.
+func (c *configFile) apply(settings []setting, skip map[string]bool) {
+	for _, s := range settings {
+		if skip[s.name] {
+			continue
+		}
+		if flag.Lookup(s.name) == nil {
+			log.Fatalf("%s:%d: unknown flag %q", c.path, s.line, s.name)
+		}
+		err := flag.Set(s.name, s.value)
+		if err != nil {
+			log.Fatalf("%s:%d: invalid value %q for %s: %v", c.path, s.line, s.value, s.name, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/csv"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+var csvLogHeader = []string{"timestamp", "duration", "peak", "floor", "snr_db", "stop_reason"}
+
+// appendCSVLog appends a row describing rec to the CSV file at path,
+// writing the header first if the file is new.
+func appendCSVLog(path string, rec *recording, samples []int16) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		w.Write(csvLogHeader)
+	}
+	snr := "" // left empty when no speech was detected
+	if v := rec.snr(); !math.IsNaN(v) {
+		snr = strconv.FormatFloat(v, 'f', 1, 64)
+	}
+	w.Write([]string{
+		rec.start.Format(time.RFC3339),
+		strconv.FormatFloat(rec.format.duration(rec.audio.Len()).Seconds(), 'f', 3, 64),
+		strconv.FormatFloat(peakLevel(samples), 'f', 4, 64),
+		strconv.FormatFloat(rec.ambientFloor, 'f', 4, 64),
+		snr,
+		rec.stopReason,
+	})
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+const (
+	denoiseFrameMs       = 32   // STFT frame length, rounded up to a power of two
+	denoiseOversubtract  = 2    // multiple of the noise spectrum taken off
+	denoiseSpectralFloor = 0.05 // least fraction of each bin's magnitude kept
+)
+
+// denoise reduces steady background noise by spectral subtraction. The
+// noise spectrum of each channel is the average over the detector's
+// calibration window at the start of the recording, which holds only the
+// room; every frame of the recording then has that spectrum, scaled up to
+// catch the noise's variance, taken off its magnitudes while keeping their
+// phase. The remainder is floored so that quiet bins become faint rather
+// than silent, which would leave musical noise.
+func denoise(samples []int16, channels, rate int) {
+	size := 1 << bits.Len(uint(denoiseFrameMs*rate/1000-1))
+	hop := size / 2
+	frames := len(samples) / channels
+	if frames < size {
+		return
+	}
+
+	// A square-root Hann window applied on the way in and again on the way
+	// out sums to one at half-frame overlap.
+	window := make([]float64, size)
+	for i := range window {
+		window[i] = math.Sqrt(0.5 * (1 - math.Cos(2*math.Pi*float64(i)/float64(size))))
+	}
+	calibration := min(frames, windowSeconds*rate)
+
+	x := make([]float64, frames+2*size)
+	out := make([]float64, len(x))
+	bins := make([]complex128, size)
+	noise := make([]float64, size)
+	for c := range channels {
+		clear(x)
+		clear(out)
+		for i := range frames {
+			x[hop+i] = float64(samples[i*channels+c])
+		}
+
+		clear(noise)
+		n := 0
+		for start := hop; start+size <= hop+calibration; start += hop {
+			stftFrame(bins, x[start:start+size], window)
+			for k, b := range bins {
+				noise[k] += cmplx.Abs(b)
+			}
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		for k := range noise {
+			noise[k] /= float64(n)
+		}
+
+		for start := 0; start+size <= len(x); start += hop {
+			stftFrame(bins, x[start:start+size], window)
+			for k, b := range bins {
+				mag := cmplx.Abs(b)
+				if mag == 0 {
+					continue
+				}
+				kept := max(mag-denoiseOversubtract*noise[k], denoiseSpectralFloor*mag)
+				bins[k] = b * complex(kept/mag, 0)
+			}
+			fft(bins, true)
+			for i, b := range bins {
+				out[start+i] += real(b) * window[i]
+			}
+		}
+
+		for i := range frames {
+			samples[i*channels+c] = clampSample(out[hop+i])
+		}
+	}
+}
+
+// stftFrame windows frame into bins and transforms it.
+func stftFrame(bins []complex128, frame, window []float64) {
+	for i, v := range frame {
+		bins[i] = complex(v*window[i], 0)
+	}
+	fft(bins, false)
+}
+
+// fft transforms a in place, whose length must be a power of two. The
+// inverse transform includes the 1/n scaling.
+func fft(a []complex128, inverse bool) {
+	n := len(a)
+	shift := bits.UintSize - bits.Len(uint(n-1))
+	for i := range a {
+		j := int(bits.Reverse(uint(i)) >> shift)
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for length := 2; length <= n; length <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(length))
+		for start := 0; start < n; start += length {
+			w := complex(1, 0)
+			for k := range length / 2 {
+				u, v := a[start+k], a[start+k+length/2]*w
+				a[start+k], a[start+k+length/2] = u+v, u-v
+				w *= step
+			}
+		}
+	}
+
+	if inverse {
+		for i := range a {
+			a[i] /= complex(float64(n), 0)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// listHostApis prints every portaudio host API with its devices and
+// defaults.
+func listHostApis() error {
+	hosts, err := portaudio.HostApis()
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hosts {
+		fmt.Printf("%s (%d devices)\n", h.Name, len(h.Devices))
+		fmt.Printf("  default input:  %s\n", deviceName(h.DefaultInputDevice))
+		fmt.Printf("  default output: %s\n", deviceName(h.DefaultOutputDevice))
+	}
+	return nil
+}
+
+// listDevices prints every device under its host API, with its input
+// channels and default rate, for the devices subcommand.
+func listDevices() error {
+	hosts, err := portaudio.HostApis()
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hosts {
+		fmt.Printf("%s:\n", h.Name)
+		for _, d := range h.Devices {
+			var defaults []string
+			if d == h.DefaultInputDevice {
+				defaults = append(defaults, "default input")
+			}
+			if d == h.DefaultOutputDevice {
+				defaults = append(defaults, "default output")
+			}
+			fmt.Printf("  %s: %d input channels, %.0f Hz", d.Name, d.MaxInputChannels, d.DefaultSampleRate)
+			if len(defaults) > 0 {
+				fmt.Printf(" (%s)", strings.Join(defaults, ", "))
+			}
+			fmt.Println()
+		}
+	}
+	return nil
+}
+
+func deviceName(d *portaudio.DeviceInfo) string {
+	if d == nil {
+		return "none"
+	}
+	return d.Name
+}
+
+// defaultInputName names the device recordings are taken from.
+func defaultInputName() string {
+	d, err := portaudio.DefaultInputDevice()
+	if err != nil {
+		return "unknown device"
+	}
+	return deviceName(d)
+}
+
+// inputDevice is one --input-device: a device name and the gain its audio
+// is mixed in with.
+type inputDevice struct {
+	name   string
+	gainDB float64
+}
+
+// deviceList collects repeated --input-device flags, each given as NAME or
+// NAME@GAIN_DB.
+type deviceList []inputDevice
+
+func (l *deviceList) String() string {
+	names := make([]string, len(*l))
+	for i, d := range *l {
+		names[i] = d.name
+	}
+	return strings.Join(names, ", ")
+}
+
+func (l *deviceList) Set(v string) error {
+	d := inputDevice{name: v}
+	if i := strings.LastIndex(v, "@"); i >= 0 {
+		gain, err := strconv.ParseFloat(v[i+1:], 64)
+		if err != nil {
+			return fmt.Errorf("bad gain in %q: %w", v, err)
+		}
+		d = inputDevice{name: v[:i], gainDB: gain}
+	}
+	*l = append(*l, d)
+	return nil
+}
+
+// findInputDevice returns the input device called name, or failing that
+// the only one whose name contains it, ignoring case.
+func findInputDevice(name string) (*portaudio.DeviceInfo, error) {
+	devices, err := portaudio.Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*portaudio.DeviceInfo
+	for _, d := range devices {
+		if d.MaxInputChannels == 0 {
+			continue
+		}
+		if d.Name == name {
+			return d, nil
+		}
+		if strings.Contains(strings.ToLower(d.Name), strings.ToLower(name)) {
+			matches = append(matches, d)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, withKind(errNoInputDevice, fmt.Errorf("no input device matches %q (see raus devices)", name))
+	case 1:
+		return matches[0], nil
+	}
+	return nil, fmt.Errorf("%q matches %d input devices, be more specific", name, len(matches))
+}
+
+// inputName names the device or devices recordings are taken from.
+func inputName(cfg config) string {
+	if len(cfg.inputDevices) == 0 {
+		return defaultInputName()
+	}
+	names := make([]string, len(cfg.inputDevices))
+	for i, d := range cfg.inputDevices {
+		names[i] = d.name
+		if device, err := findInputDevice(d.name); err == nil {
+			names[i] = device.Name
+		}
+	}
+	return strings.Join(names, " + ")
+}
+
+// warnSelection points out when the --input-device names were resolved to
+// something other than what they literally say, and names the system
+// default input alongside, since recording from the wrong microphone is
+// easy while the default keeps changing under the selection.
+func warnSelection(cfg config) {
+	if len(cfg.inputDevices) == 0 {
+		return
+	}
+	def := defaultInputName()
+	usesDefault := false
+	for _, d := range cfg.inputDevices {
+		device, err := findInputDevice(d.name)
+		if err != nil {
+			return // reported when the device is opened
+		}
+		if !strings.EqualFold(device.Name, d.name) {
+			log.Printf("--input-device %q is taken to mean %q", d.name, device.Name)
+		}
+		usesDefault = usesDefault || device.Name == def
+	}
+	if !usesDefault {
+		log.Printf("recording from the selected input rather than the system default, %s", def)
+	}
+}
+
+// autoDeviceProbe is how long --auto-device listens to each input.
+const autoDeviceProbe = 500 * time.Millisecond
+
+// loudestInput listens briefly to every input device that can record
+// --channels and returns the name of the one hearing the most, which is
+// most likely the microphone in use.
+func loudestInput(cfg config) (string, error) {
+	devices, err := portaudio.Devices()
+	if err != nil {
+		return "", classifyAudioError(err)
+	}
+
+	probe := cfg
+	probe.monitor = false
+	probe.muteFirstMs = 0
+	best, bestLevel := "", -1.0
+	for _, d := range devices {
+		if d.MaxInputChannels < cfg.channels {
+			continue
+		}
+		level, err := probeLevel(probe, d)
+		if err != nil {
+			log.Printf("skipping %s: %v", d.Name, err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "  %-40s %6.1f dBFS\n", d.Name, toDBFS(level))
+		if level > bestLevel {
+			best, bestLevel = d.Name, level
+		}
+	}
+	if best == "" {
+		return "", withKind(errNoInputDevice, errors.New("no input device could be probed"))
+	}
+	return best, nil
+}
+
+// probeLevel returns the mean level device hears over autoDeviceProbe.
+func probeLevel(cfg config, device *portaudio.DeviceInfo) (float64, error) {
+	rate := sampleRate
+	if !supportsRate(device, cfg.channels, rate) {
+		rate = int(device.DefaultSampleRate)
+	}
+	src, err := openStreamSource(cfg, device, rate)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	var sum float64
+	var frames int
+	for frames < int(autoDeviceProbe.Seconds()*float64(src.rate)) {
+		in, err := src.Read()
+		if err != nil && err != portaudio.InputOverflowed {
+			return 0, err
+		}
+		for i := 0; i+cfg.channels <= len(in); i += cfg.channels {
+			sum += frameAmplitude(in[i : i+cfg.channels])
+			frames++
+		}
+	}
+	return sum / float64(frames), nil
+}
+
+// supportsRate reports whether device can capture channels at rate.
+func supportsRate(device *portaudio.DeviceInfo, channels, rate int) bool {
+	p := portaudio.HighLatencyParameters(device, nil)
+	p.Input.Channels = channels
+	p.SampleRate = float64(rate)
+	return portaudio.IsFormatSupported(p, make([]int16, channels)) == nil
+}
+
+// checkRate returns an error listing the rates device, or the default
+// input if it is nil, does support when it cannot capture channels at rate.
+func checkRate(device *portaudio.DeviceInfo, channels, rate int) error {
+	if device == nil {
+		var err error
+		device, err = portaudio.DefaultInputDevice()
+		if err != nil {
+			return classifyAudioError(err)
+		}
+	}
+	if supportsRate(device, channels, rate) {
+		return nil
+	}
+
+	var supported []int
+	for _, r := range speechRates {
+		if supportsRate(device, channels, r) {
+			supported = append(supported, r)
+		}
+	}
+	if len(supported) == 0 {
+		return withKind(errUnsupportedFormat, fmt.Errorf("%s cannot record %d channel(s) at any of %s Hz", device.Name, channels, formatRates(speechRates)))
+	}
+	return withKind(errUnsupportedFormat, fmt.Errorf("%s cannot record at %d Hz; try --rate %s", device.Name, rate, formatRates(supported)))
+}
+
+// formatRates lists rates for a message, as "8000, 16000 or 48000".
+func formatRates(rates []int) string {
+	s := make([]string, len(rates))
+	for i, r := range rates {
+		s[i] = strconv.Itoa(r)
+	}
+	if len(s) == 1 {
+		return s[0]
+	}
+	return strings.Join(s[:len(s)-1], ", ") + " or " + s[len(s)-1]
+}
+
+// probeChannels are the channel counts --list-formats tries.
+var probeChannels = []int{1, 2, 4, 6, 8}
+
+// listFormats prints the rates and channel counts the --input-device, or
+// the default input, accepts for the 16-bit capture raus does, and the
+// sample types it takes at its default rate.
+func listFormats(cfg config) error {
+	device, err := portaudio.DefaultInputDevice()
+	if len(cfg.inputDevices) > 0 {
+		device, err = findInputDevice(cfg.inputDevices[0].name)
+	}
+	if err != nil {
+		return classifyAudioError(err)
+	}
+
+	fmt.Printf("%s (%s, default %g Hz)\n", device.Name, device.HostApi.Name, device.DefaultSampleRate)
+	for _, channels := range probeChannels {
+		if channels > device.MaxInputChannels {
+			break
+		}
+		var rates []string
+		for _, r := range speechRates {
+			if supportsRate(device, channels, r) {
+				rates = append(rates, strconv.Itoa(r))
+			}
+		}
+		if len(rates) == 0 {
+			rates = []string{"none"}
+		}
+		fmt.Printf("  %d ch: %s Hz\n", channels, strings.Join(rates, " "))
+	}
+
+	types := []struct {
+		name   string
+		buffer any
+	}{
+		{"int8", make([]int8, 1)},
+		{"int16", make([]int16, 1)},
+		{"int32", make([]int32, 1)},
+		{"float32", make([]float32, 1)},
+	}
+	var supported []string
+	p := portaudio.HighLatencyParameters(device, nil)
+	p.Input.Channels = 1
+	for _, t := range types {
+		if portaudio.IsFormatSupported(p, t.buffer) == nil {
+			supported = append(supported, t.name)
+		}
+	}
+	fmt.Printf("  sample types at %g Hz, 1 ch: %s\n", device.DefaultSampleRate, strings.Join(supported, " "))
+	return nil
+}
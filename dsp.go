@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+)
+
+// gateWindowMs is the length of audio over which the gate measures the
+// level before deciding whether to silence it.
+const gateWindowMs = 10
+
+func decodeSamples(data []byte) []int16 {
+	samples := make([]int16, len(data)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[2*i:]))
+	}
+	return samples
+}
+
+// encodePCM encodes samples as little-endian PCM of the given bit depth.
+// 8-bit PCM is unsigned (offset binary), as WAV requires, and 32 bits means
+// IEEE floats; every int16 sample converts to one exactly.
+func encodePCM(samples []int16, bitDepth int) []byte {
+	switch bitDepth {
+	case 16:
+		return encodeSamples(samples)
+	case 32:
+		data := make([]byte, 4*len(samples))
+		for i, s := range samples {
+			binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(float32(s)/fullScale))
+		}
+		return data
+	}
+
+	data := make([]byte, len(samples))
+	for i, s := range samples {
+		data[i] = uint8(int(to8Bit(s)) + 128)
+	}
+	return data
+}
+
+// to8Bit rounds a 16-bit sample to 8 bits.
+func to8Bit(s int16) int8 {
+	return int8(min((int(s)+128)>>8, math.MaxInt8))
+}
+
+// encodeBigEndianPCM encodes samples as big-endian PCM of the given bit
+// depth, as AIFF and CAF hold it; their 8-bit samples are signed.
+func encodeBigEndianPCM(samples []int16, bitDepth int) []byte {
+	if bitDepth == 8 {
+		data := make([]byte, len(samples))
+		for i, s := range samples {
+			data[i] = byte(to8Bit(s))
+		}
+		return data
+	}
+	data := make([]byte, 2*len(samples))
+	for i, s := range samples {
+		binary.BigEndian.PutUint16(data[2*i:], uint16(s))
+	}
+	return data
+}
+
+func encodeSamples(samples []int16) []byte {
+	data := make([]byte, 2*len(samples))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(s))
+	}
+	return data
+}
+
+// msToSamples converts milliseconds to a number of frames.
+func msToSamples(ms int) int {
+	return ms * sampleRate / 1000
+}
+
+// fullScale is the magnitude of the most negative int16 sample, so that
+// normalised amplitudes never exceed 1.
+const fullScale = -math.MinInt16
+
+// amplitude returns the magnitude of a sample normalised to [0, 1].
+func amplitude(sample int16) float64 {
+	return math.Abs(float64(sample)) / fullScale
+}
+
+// toDBFS converts a normalised level to dBFS, bottoming out at -99.
+func toDBFS(v float64) float64 {
+	if v <= 0 {
+		return -99
+	}
+	return max(-99, 20*math.Log10(v))
+}
+
+func dbToGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// applyGain scales samples in place, clamping to the int16 range or, with
+// soft set, rounding the peaks off with softClip.
+func applyGain(samples []int16, gain float64, soft bool) {
+	for i, s := range samples {
+		samples[i] = limitSample(float64(s)*gain, soft)
+	}
+}
+
+// softClipKnee is the fraction of full scale above which softClip starts
+// to bend the signal.
+const softClipKnee = 0.7
+
+// softClip passes v through unchanged up to the knee and compresses what
+// lies above it with a tanh curve that approaches, but never reaches,
+// full scale, so loud peaks are rounded off instead of squared off.
+func softClip(v float64) float64 {
+	knee := softClipKnee * fullScale
+	a := math.Abs(v)
+	if a <= knee {
+		return v
+	}
+	headroom := fullScale - knee
+	return math.Copysign(knee+headroom*math.Tanh((a-knee)/headroom), v)
+}
+
+// limitSample converts v to a sample, through softClip if soft is set.
+func limitSample(v float64, soft bool) int16 {
+	if soft {
+		v = softClip(v)
+	}
+	return clampSample(v)
+}
+
+func clampSample(v float64) int16 {
+	return int16(max(math.MinInt16, min(math.MaxInt16, math.Round(v))))
+}
+
+// peakLevel returns the largest normalised amplitude in samples.
+func peakLevel(samples []int16) float64 {
+	peak := 0.0
+	for _, s := range samples {
+		peak = max(peak, amplitude(s))
+	}
+	return peak
+}
+
+// mixInto adds the mono float signal to every channel of the interleaved
+// samples starting at frame, clamping the sum. Parts falling outside
+// samples are dropped.
+func mixInto(samples []int16, channels int, signal []float32, frame int) {
+	for i, v := range signal {
+		for ch := 0; ch < channels; ch++ {
+			j := (frame+i)*channels + ch
+			if j < 0 || j >= len(samples) {
+				continue
+			}
+			samples[j] = clampSample(float64(samples[j]) + float64(v)*math.MaxInt16)
+		}
+	}
+}
+
+// frameAmplitude returns the mean normalised amplitude across the
+// channels of one frame.
+func frameAmplitude(frame []int16) float64 {
+	sum := 0.0
+	for _, s := range frame {
+		sum += amplitude(s)
+	}
+	return sum / float64(len(frame))
+}
+
+// splitChannels de-interleaves samples into one slice per channel.
+func splitChannels(samples []int16, channels int) [][]int16 {
+	split := make([][]int16, channels)
+	for ch := range split {
+		split[ch] = make([]int16, 0, len(samples)/channels)
+	}
+	for i, s := range samples {
+		split[i%channels] = append(split[i%channels], s)
+	}
+	return split
+}
+
+// countClipped returns how many samples sit at full scale.
+func countClipped(samples []int16) int {
+	n := 0
+	for _, s := range samples {
+		if s == math.MaxInt16 || s == math.MinInt16 {
+			n++
+		}
+	}
+	return n
+}
+
+// countNonzeroPCM counts the nonzero samples of 16-bit little-endian PCM
+// without decoding it.
+func countNonzeroPCM(data []byte) int {
+	n := 0
+	for i := 0; i+1 < len(data); i += 2 {
+		if data[i]|data[i+1] != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func countNonzero(samples []int16) int {
+	n := 0
+	for _, s := range samples {
+		if s != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// applyGate zeroes every gate window whose average level falls below
+// threshold, keeping the length of the recording intact. All channels of a
+// frame are gated together.
+func applyGate(samples []int16, channels int, threshold float64) {
+	size := msToSamples(gateWindowMs) * channels
+	window := make([]float64, size)
+	for start := 0; start < len(samples); start += size {
+		block := samples[start:min(start+size, len(samples))]
+		levels := window[:len(block)]
+		for i, s := range block {
+			levels[i] = amplitude(s)
+		}
+
+		if calculateAverage(levels) < threshold {
+			clear(block)
+		}
+	}
+}
+
+// padSilence surrounds samples with before and after samples of digital
+// silence.
+func padSilence(samples []int16, before, after int) []int16 {
+	if before == 0 && after == 0 {
+		return samples
+	}
+
+	padded := make([]int16, before+len(samples)+after)
+	copy(padded[before:], samples)
+	return padded
+}
+
+// crossfade joins segments of interleaved samples, overlapping each pair
+// by fade frames blended with a linear ramp. The overlap is clamped to the
+// shorter of the two segments.
+func crossfade(segments [][]int16, channels, fade int) []int16 {
+	var out []int16
+	for _, seg := range segments {
+		n := min(fade, len(out)/channels, len(seg)/channels) * channels
+		tail := out[len(out)-n:]
+		for i := range tail {
+			t := float64(i/channels+1) / float64(n/channels+1)
+			tail[i] = clampSample(float64(tail[i])*(1-t) + float64(seg[i])*t)
+		}
+		out = append(out, seg[n:]...)
+	}
+	return out
+}
+
+// fadeOut ramps the last frames of interleaved samples linearly down to
+// silence.
+func fadeOut(samples []int16, channels, frames int) {
+	n := min(frames, len(samples)/channels)
+	start := len(samples)/channels - n
+	for f := range n {
+		g := float64(n-f) / float64(n+1)
+		for ch := range channels {
+			i := (start+f)*channels + ch
+			samples[i] = clampSample(float64(samples[i]) * g)
+		}
+	}
+}
+
+// reduceBits zeroes the low bits of samples, keeping only the top bits of
+// each, so that lossless compressors have less to store. Triangular
+// dither is added first so that the quantisation error is heard as a
+// steady hiss rather than as distortion that follows the signal.
+func reduceBits(samples []int16, bits int) {
+	step := float64(int(1) << (16 - bits))
+	for i, s := range samples {
+		d := (rand.Float64() - rand.Float64()) * step
+		q := math.Round((float64(s)+d)/step) * step
+		samples[i] = int16(max(math.MinInt16, min(math.MaxInt16+1-step, q)))
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// envelopeInterval is the span of audio, in milliseconds, summarised by
+// each envelope value.
+const envelopeInterval = 10
+
+// computeEnvelope returns the average amplitude of each envelopeInterval
+// of interleaved samples, on the same scale as the detector's level.
+func computeEnvelope(samples []int16, channels int) []float64 {
+	step := msToSamples(envelopeInterval) * channels
+	var env []float64
+	for i := 0; i < len(samples); i += step {
+		block := samples[i:min(i+step, len(samples))]
+		levels := make([]float64, 0, len(block)/channels)
+		for j := 0; j+channels <= len(block); j += channels {
+			levels = append(levels, frameAmplitude(block[j:j+channels]))
+		}
+		if len(levels) > 0 {
+			env = append(env, calculateAverage(levels))
+		}
+	}
+	return env
+}
+
+// writeEnvelope writes env to path, as time,level rows if the name ends in
+// .csv and as little-endian float32 values otherwise.
+func writeEnvelope(path string, env []float64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		w := csv.NewWriter(f)
+		w.Write([]string{"time", "level"})
+		for i, v := range env {
+			w.Write([]string{
+				strconv.FormatFloat(float64(i*envelopeInterval)/1000, 'f', 3, 64),
+				strconv.FormatFloat(v, 'f', 6, 64),
+			})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+
+	values := make([]float32, len(env))
+	for i, v := range env {
+		values[i] = float32(v)
+	}
+	err = binary.Write(f, binary.LittleEndian, values)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
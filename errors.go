@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+var errClipped = errors.New("input clipped during recording")
+var errNoSignal = errors.New("no speech detected before --arm-timeout, is the microphone working?")
+var errCancelled = errors.New("recording cancelled")
+var errSilentInput = errors.New("input appears to be silent, is the mic muted?")
+
+// Kinds of failure to open the input, which errors from portaudio and from
+// device lookup are marked with so that they can be told apart with
+// errors.Is.
+var (
+	errNoInputDevice     = errors.New("no input device")
+	errDeviceBusy        = errors.New("input device busy")
+	errUnsupportedFormat = errors.New("unsupported format")
+)
+
+// exitNoSignal is the exit status used when --arm-timeout expires or the
+// input is silent, so that wrappers can tell a dead microphone apart from
+// other failures.
+const exitNoSignal = 3
+
+// exitCancelled is the exit status used when the recording is cancelled
+// and nothing is written.
+const exitCancelled = 4
+
+// exitCodes maps errors to the exit status fatal uses for them. Anything
+// else exits with 1.
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{errNoSignal, exitNoSignal},
+	{errSilentInput, exitNoSignal},
+	{errCancelled, exitCancelled},
+	{errNoInputDevice, 5},
+	{errDeviceBusy, 6},
+	{errUnsupportedFormat, 7},
+}
+
+// kindError is an error marked as being of a kind, keeping its own message.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+func withKind(kind, err error) error {
+	return &kindError{kind, err}
+}
+
+// classifyAudioError marks the portaudio errors that say something about
+// the device with their kind. Others are returned unchanged.
+func classifyAudioError(err error) error {
+	var pe portaudio.Error
+	if !errors.As(err, &pe) {
+		return err
+	}
+	switch pe {
+	case portaudio.NoDefaultInputDevice, portaudio.InvalidDevice:
+		return withKind(errNoInputDevice, err)
+	case portaudio.DeviceUnavailable:
+		return withKind(errDeviceBusy, err)
+	case portaudio.InvalidSampleRate, portaudio.InvalidChannelCount, portaudio.SampleFormatNotSupported:
+		return withKind(errUnsupportedFormat, err)
+	}
+	return err
+}
+
+// exitCode returns the exit status for err.
+func exitCode(err error) int {
+	for _, c := range exitCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return 1
+}
+
+// fatal logs err and exits with a status that reflects its cause.
+func fatal(err error) {
+	log.Print(err)
+	os.Exit(exitCode(err))
+}
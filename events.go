@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// eventWriter sends the capture's status to --event-fifo as JSON lines,
+// for a GUI to follow without parsing stderr. Like the --tui view it
+// writes on every change of state and otherwise every tuiRefresh. Events
+// the reader is not keeping up with are dropped rather than holding up
+// the capture.
+type eventWriter struct {
+	pipe eventPipe
+	last status
+	sent time.Time
+}
+
+// eventPipe is where the events go. write must never block.
+type eventPipe interface {
+	write(data []byte)
+	Close() error
+}
+
+// statusEvent is one line sent to --event-fifo.
+type statusEvent struct {
+	State   string  `json:"state"`
+	Level   float64 `json:"level"`
+	Floor   float64 `json:"floor"`
+	Elapsed float64 `json:"elapsed"`          // seconds captured
+	Reason  string  `json:"reason,omitempty"` // why the recording stopped
+}
+
+func openEvents(path string) (*eventWriter, error) {
+	pipe, err := openEventPipe(path)
+	if err != nil {
+		return nil, err
+	}
+	return &eventWriter{pipe: pipe}, nil
+}
+
+func (e *eventWriter) send(s status) {
+	now := time.Now()
+	if s.State == e.last.State && now.Sub(e.sent) < tuiRefresh {
+		return
+	}
+	e.last, e.sent = s, now
+	e.emit(statusEvent{State: s.State, Level: s.Level, Floor: s.Floor, Elapsed: s.Elapsed.Seconds()})
+}
+
+// stop sends the final event, with why the recording ended if it did so
+// normally.
+func (e *eventWriter) stop(reason string) {
+	s := e.last
+	e.emit(statusEvent{State: "stopped", Level: s.Level, Floor: s.Floor, Elapsed: s.Elapsed.Seconds(), Reason: reason})
+}
+
+func (e *eventWriter) emit(ev statusEvent) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	e.pipe.write(append(data, '\n'))
+}
+
+func (e *eventWriter) Close() error {
+	return e.pipe.Close()
+}
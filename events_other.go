@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// openEventPipe is not implemented on this platform.
+func openEventPipe(path string) (eventPipe, error) {
+	return nil, errors.New("--event-fifo is not supported on this platform")
+}
@@ -0,0 +1,61 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fifoPipe writes to a named pipe without ever waiting on its reader. The
+// pipe is opened non-blocking, which fails while nobody has it open for
+// reading, so it is opened again on each write until a reader turns up,
+// and again after one goes away.
+type fifoPipe struct {
+	path string
+	fd   int // -1 while there is no reader
+}
+
+// openEventPipe creates the FIFO at path if there is nothing there yet.
+func openEventPipe(path string) (eventPipe, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		err = syscall.Mkfifo(path, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("could not create --event-fifo: %w", err)
+		}
+	} else if err != nil {
+		return nil, err
+	} else if info.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("--event-fifo %s exists and is not a named pipe", path)
+	}
+	return &fifoPipe{path: path, fd: -1}, nil
+}
+
+func (p *fifoPipe) write(data []byte) {
+	if p.fd < 0 {
+		fd, err := syscall.Open(p.path, syscall.O_WRONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return // ENXIO: no reader yet
+		}
+		p.fd = fd
+	}
+	// A full pipe (EAGAIN) drops the event; a partial write cannot happen
+	// for lines shorter than PIPE_BUF.
+	_, err := syscall.Write(p.fd, data)
+	if err == syscall.EPIPE {
+		syscall.Close(p.fd)
+		p.fd = -1
+	}
+}
+
+func (p *fifoPipe) Close() error {
+	if p.fd < 0 {
+		return nil
+	}
+	err := syscall.Close(p.fd)
+	p.fd = -1
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import "math"
+
+// butterworthQ gives a maximally flat passband.
+const butterworthQ = math.Sqrt2 / 2
+
+// biquad is a second-order IIR filter section (RBJ audio EQ cookbook).
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func newHighPass(freq, rate float64) *biquad {
+	w := 2 * math.Pi * freq / rate
+	alpha := math.Sin(w) / (2 * butterworthQ)
+	cos := math.Cos(w)
+	a0 := 1 + alpha
+	return &biquad{
+		b0: (1 + cos) / 2 / a0,
+		b1: -(1 + cos) / a0,
+		b2: (1 + cos) / 2 / a0,
+		a1: -2 * cos / a0,
+		a2: (1 - alpha) / a0,
+	}
+}
+
+func newLowPass(freq, rate float64) *biquad {
+	w := 2 * math.Pi * freq / rate
+	alpha := math.Sin(w) / (2 * butterworthQ)
+	cos := math.Cos(w)
+	a0 := 1 + alpha
+	return &biquad{
+		b0: (1 - cos) / 2 / a0,
+		b1: (1 - cos) / a0,
+		b2: (1 - cos) / 2 / a0,
+		a1: -2 * cos / a0,
+		a2: (1 - alpha) / a0,
+	}
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// filterChain runs samples through a cascade of biquads, keeping state
+// between calls so it can be fed frame by frame.
+type filterChain []*biquad
+
+// newVoiceFilter builds a band-pass for the speech band between low and
+// high Hz, for audio at rate. The upper corner is kept below Nyquist.
+func newVoiceFilter(low, high float64, rate int) filterChain {
+	high = min(high, 0.45*float64(rate))
+	return filterChain{newHighPass(low, float64(rate)), newLowPass(high, float64(rate))}
+}
+
+func (c filterChain) process(v float64) float64 {
+	for _, f := range c {
+		v = f.process(v)
+	}
+	return v
+}
+
+// channelFilters holds one filter chain per channel of interleaved audio.
+type channelFilters []filterChain
+
+func (c channelFilters) apply(samples []int16) {
+	if len(c) == 0 {
+		return
+	}
+	for i, s := range samples {
+		samples[i] = clampSample(c[i%len(c)].process(float64(s)))
+	}
+}
+
+// applyPreemphasis runs the first-order high-pass y[n] = x[n] - coef*x[n-1]
+// over each channel of samples, as many speech recognisers expect.
+func applyPreemphasis(samples []int16, channels int, coef float64) {
+	prev := make([]float64, channels)
+	for i, s := range samples {
+		ch := i % channels
+		x := float64(s)
+		samples[i] = clampSample(x - coef*prev[ch])
+		prev[ch] = x
+	}
+}
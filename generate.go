@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"time"
+)
+
+// generatedRecording wraps synthesised samples in a recording so that they
+// go through the same output path as captured audio.
+func generatedRecording(samples []int16, cfg config) *recording {
+	return &recording{
+		audio:      bytes.NewBuffer(encodeSamples(samples)),
+		format:     cfg.captureFormat(),
+		start:      time.Now(),
+		stopReason: "generated",
+	}
+}
+
+// writeGenerated writes a generated recording like writeRecording does. It
+// is never captured, so with --stream it goes to stdout here, unprocessed
+// as a stream would be, and --output still gets the finished file.
+func writeGenerated(rec *recording, cfg config) error {
+	if cfg.stream {
+		err := writeWAV(os.Stdout, rec.format, rec.audio.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return writeRecording(rec, cfg)
+}
+
+// generateSilence returns seconds of digital silence in the capture format.
+func generateSilence(seconds float64, cfg config) []int16 {
+	return make([]int16, int(seconds*float64(sampleRate))*cfg.channels)
+}
@@ -0,0 +1,42 @@
+package main
+
+// hotkey watches for a global key combination, given to --hotkey as
+// names joined with "+", such as ctrl+alt+r. It signals once each time
+// the whole combination goes down and once each time it comes back up,
+// whichever window has the focus.
+type hotkey struct {
+	combo    string
+	pressed  chan struct{}
+	released chan struct{}
+	close    func() error
+}
+
+func newHotkey(combo string, close func() error) *hotkey {
+	return &hotkey{
+		combo:    combo,
+		pressed:  make(chan struct{}, 1),
+		released: make(chan struct{}, 1),
+		close:    close,
+	}
+}
+
+// set records that the combination went down or came back up. A reader
+// that has not caught up with the last edge only gets the latest one.
+func (h *hotkey) set(down bool) {
+	c, other := h.released, h.pressed
+	if down {
+		c, other = h.pressed, h.released
+	}
+	select {
+	case <-other:
+	default:
+	}
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+func (h *hotkey) Close() error {
+	return h.close()
+}
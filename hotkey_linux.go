@@ -0,0 +1,142 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// The hotkey is read from the kernel's input devices rather than through
+// X11 or Wayland, so it works the same under any display server and in a
+// console. The keys still reach the focused window, since raus does not
+// grab the devices, and reading them needs membership of the input group
+// (or root).
+
+const evKey = 1 // EV_KEY, the event type of key presses and releases
+
+// inputEventSize is the size of a struct input_event: a timeval followed
+// by a 16-bit type, a 16-bit code and a 32-bit value.
+var inputEventSize = int(unsafe.Sizeof(syscall.Timeval{})) + 8
+
+// modifierCodes map modifier names to the key codes of their left and
+// right keys.
+var modifierCodes = map[string][]uint16{
+	"ctrl":    {29, 97},
+	"control": {29, 97},
+	"shift":   {42, 54},
+	"alt":     {56, 100},
+	"super":   {125, 126},
+	"meta":    {125, 126},
+	"win":     {125, 126},
+}
+
+// keyCodes map the other key names --hotkey takes to their codes, from
+// linux/input-event-codes.h.
+var keyCodes = map[string]uint16{
+	"esc": 1, "1": 2, "2": 3, "3": 4, "4": 5, "5": 6, "6": 7, "7": 8, "8": 9, "9": 10, "0": 11,
+	"tab": 15, "q": 16, "w": 17, "e": 18, "r": 19, "t": 20, "y": 21, "u": 22, "i": 23, "o": 24, "p": 25,
+	"enter": 28, "a": 30, "s": 31, "d": 32, "f": 33, "g": 34, "h": 35, "j": 36, "k": 37, "l": 38,
+	"z": 44, "x": 45, "c": 46, "v": 47, "b": 48, "n": 49, "m": 50, "space": 57,
+	"f1": 59, "f2": 60, "f3": 61, "f4": 62, "f5": 63, "f6": 64, "f7": 65, "f8": 66, "f9": 67, "f10": 68,
+	"scrolllock": 70, "f11": 87, "f12": 88, "pause": 119,
+}
+
+// parseHotkey turns a combination such as ctrl+alt+r into the keys that
+// have to be held, each given as the codes that count for it.
+func parseHotkey(combo string) ([][]uint16, error) {
+	var keys [][]uint16
+	for _, name := range strings.Split(strings.ToLower(combo), "+") {
+		name = strings.TrimSpace(name)
+		if codes, ok := modifierCodes[name]; ok {
+			keys = append(keys, codes)
+		} else if code, ok := keyCodes[name]; ok {
+			keys = append(keys, []uint16{code})
+		} else {
+			return nil, fmt.Errorf("unknown key %q in --hotkey %q", name, combo)
+		}
+	}
+	return keys, nil
+}
+
+func openHotkey(combo string) (*hotkey, error) {
+	keys, err := parseHotkey(combo)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, _ := filepath.Glob("/dev/input/event*")
+	var devices []*os.File
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err == nil {
+			devices = append(devices, f)
+		}
+	}
+	if len(devices) == 0 {
+		return nil, errors.New("--hotkey needs read access to /dev/input/event*; add yourself to the input group")
+	}
+
+	h := newHotkey(combo, func() error {
+		var err error
+		for _, f := range devices {
+			err = errors.Join(err, f.Close())
+		}
+		return err
+	})
+	var mu sync.Mutex
+	held := map[uint16]bool{}
+	down := false
+	for _, f := range devices {
+		go func() {
+			buf := make([]byte, inputEventSize)
+			for {
+				_, err := io.ReadFull(f, buf)
+				if err != nil {
+					return // closed, or the device went away
+				}
+				tail := buf[inputEventSize-8:]
+				if binary.NativeEndian.Uint16(tail) != evKey {
+					continue
+				}
+				code := binary.NativeEndian.Uint16(tail[2:])
+				value := int32(binary.NativeEndian.Uint32(tail[4:]))
+				if value == 2 {
+					continue // auto-repeat
+				}
+
+				mu.Lock()
+				held[code] = value == 1
+				now := comboHeld(keys, held)
+				if now != down {
+					down = now
+					h.set(now)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	return h, nil
+}
+
+// comboHeld reports whether one of the codes of each key is held.
+func comboHeld(keys [][]uint16, held map[uint16]bool) bool {
+	for _, codes := range keys {
+		ok := false
+		for _, c := range codes {
+			ok = ok || held[c]
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
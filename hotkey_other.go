@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// openHotkey is not implemented on this platform.
+func openHotkey(combo string) (*hotkey, error) {
+	return nil, errors.New("--hotkey is only supported on Linux")
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+)
+
+// writeJSONSamples writes samples as a JSON array of floats normalised to
+// [-1, 1]. It is meant for inspecting the detector and filters, not for
+// production use: the output is many times larger than the WAV.
+func writeJSONSamples(w io.Writer, samples []int16) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('[')
+	for i, s := range samples {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteString(strconv.FormatFloat(float64(s)/fullScale, 'g', 6, 64))
+	}
+	bw.WriteString("]\n")
+	return bw.Flush()
+}
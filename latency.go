@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// latencyListen is how long --measure-latency listens for the beep.
+const latencyListen = 1500 * time.Millisecond
+
+// measureLatency plays the start beep while capturing, then finds the beep
+// in the capture by cross-correlation. The result is the round trip from
+// handing the beep to portaudio to reading it back from the input, which
+// needs a loopback cable or a microphone close to the speaker.
+func measureLatency(cfg config) (time.Duration, error) {
+	src, err := openInput(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	limit := int(latencyListen.Seconds() * float64(sampleRate))
+	captured := make([]float64, 0, limit+cfg.frameSize)
+	var frames atomic.Int64
+	done := make(chan error, 1)
+	go func() {
+		for len(captured) < limit {
+			in, err := src.Read()
+			if err != nil {
+				done <- err
+				return
+			}
+			for i := 0; i < len(in); i += cfg.channels {
+				captured = append(captured, float64(in[i])/fullScale)
+			}
+			frames.Store(int64(len(captured)))
+		}
+		done <- nil
+	}()
+
+	beep := generateBeep(beepDuration)
+	time.Sleep(200 * time.Millisecond) // let the input settle first
+	start := int(frames.Load())
+	playBeep(beep, 0)
+	err = <-done
+	if err != nil {
+		return 0, err
+	}
+
+	lag, score := findSignal(captured[start:], beep)
+	if score < beepMatch {
+		return 0, errors.New("the beep was not heard back, is the input near the output?")
+	}
+	return time.Duration(lag) * time.Second / time.Duration(sampleRate), nil
+}
+
+// findSignal returns the offset in x at which signal matches best, and
+// the normalised correlation there (1 for a perfect match).
+func findSignal(x []float64, signal []float32) (int, float64) {
+	var signalEnergy float64
+	for _, v := range signal {
+		signalEnergy += float64(v) * float64(v)
+	}
+
+	best, bestScore := 0, 0.0
+	for lag := 0; lag+len(signal) <= len(x); lag++ {
+		var dot, energy float64
+		for i, v := range signal {
+			dot += x[lag+i] * float64(v)
+			energy += x[lag+i] * x[lag+i]
+		}
+		if energy == 0 {
+			continue
+		}
+		score := dot / math.Sqrt(energy*signalEnergy)
+		if score > bestScore {
+			best, bestScore = lag, score
+		}
+	}
+	return best, bestScore
+}
@@ -2,168 +2,1530 @@ package main
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"encoding/binary"
+	"errors"
+	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"math"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/gordonklaus/portaudio"
 )
 
-const sampleRate = 16000
+// sampleRate is the rate audio is captured and written at, set by --rate.
+var sampleRate = 16000
+
+// speechRates are the rates --rate accepts.
+var speechRates = []int{8000, 11025, 12000, 16000, 22050, 24000, 44100, 48000}
+
 const beepDuration = 0.15
 const beepFrequency = 980
-const windowSize = 2 * 16000 // 2 second window for noise floor calculation
-
-type wavHeader struct {
-	ChunkID       [4]byte
-	ChunkSize     uint32
-	Format        [4]byte
-	Subchunk1ID   [4]byte
-	Subchunk1Size uint32
-	AudioFormat   uint16
-	NumChannels   uint16
-	SampleRate    uint32
-	ByteRate      uint32
-	BlockAlign    uint16
-	BitsPerSample uint16
-	Subchunk2ID   [4]byte
-	Subchunk2Size uint32
+const tickDuration = 0.05 // countdown tick length in seconds
+const windowSeconds = 2   // length of the noise floor window
+
+// minNonzeroFraction is the share of nonzero samples below which a
+// recording is taken to come from a muted or disconnected microphone.
+const minNonzeroFraction = 0.001
+
+type config struct {
+	bwf                 bool
+	inputFile           string
+	gate                bool
+	gateThreshold       float64
+	padStart            int
+	padEnd              int
+	printDuration       bool
+	abortOnClip         bool
+	clipLimit           int
+	countdown           int
+	base64              bool
+	resumeOnSignal      bool
+	outputDir           string
+	inputGainDB         float64
+	markers             bool
+	monitor             bool
+	sidetoneDelay       int
+	hash                string
+	output              string
+	format              string
+	listHostApis        bool
+	armTimeout          float64
+	voiceFilter         bool
+	voiceLow            float64
+	voiceHigh           float64
+	logCSV              string
+	samples             int
+	frameSize           int
+	mixBeep             bool
+	bitDepth            int
+	transcribeCmd       string
+	silenceGrace        int
+	channels            int
+	splitChannels       bool
+	adaptiveFloor       bool
+	floorDecay          float64
+	quiet               bool
+	noArm               bool
+	envelope            string
+	stitch              bool
+	crossfade           int
+	realtime            bool
+	latency             int
+	muteFirstMs         int
+	failOnSilence       bool
+	heartbeat           float64
+	takes               int
+	maxBytes            int
+	floorUnits          string
+	preemphasis         float64
+	generateSilence     float64
+	generateTone        float64
+	duration            float64
+	metadata            string
+	fadeMs              int
+	warmupReads         int
+	wavExtensible       bool
+	keepGoing           bool
+	maxErrors           int
+	profile             string
+	speechOnly          bool
+	measureLatency      bool
+	rotate              int
+	rotateSeconds       float64
+	inputDevices        deviceList
+	noTerminate         bool
+	strictRate          bool
+	reduceBits          int
+	beepPan             float64
+	rate                int
+	replay              bool
+	contextBefore       int
+	tui                 bool
+	configPath          string
+	statsInterval       float64
+	stream              bool
+	flushInterval       int
+	removeBeep          bool
+	forceDefaultRate    bool
+	thresholdPercentile float64
+	mmapOutput          string
+	listFormats         bool
+	pauseSilence        bool
+	hotkey              string
+	saveCalibration     string
+	loadCalibration     string
+	denoise             bool
+	armSustain          int
+	channelGate         string
+	startCmd            string
+	softClip            bool
+	vadTrace            string
+	maxFileDuration     float64
+	append              bool
+	convertOnAppend     bool
+	autoDevice          bool
+	eventFIFO           string
+}
+
+// parseFlags parses the flags of the record and test subcommands from args.
+func parseFlags(args []string) config {
+	var c config
+	flag.Usage = usage
+	flag.BoolVar(&c.bwf, "bwf", false, "write Broadcast Wave origination metadata (bext chunk)")
+	flag.Float64Var(&c.generateSilence, "generate-silence", 0, "write this many seconds of silence without opening the microphone, for testing pipelines")
+	flag.Float64Var(&c.generateTone, "generate-tone", 0, "write a sine of this frequency in Hz without opening the microphone")
+	flag.Float64Var(&c.duration, "duration", 1, "length in seconds of the --generate-tone output")
+	flag.StringVar(&c.inputFile, "input-file", "", "read audio from a 16-bit mono WAV file instead of the microphone")
+	flag.BoolVar(&c.gate, "gate", false, "zero quiet stretches of the recording instead of leaving them as captured")
+	flag.Float64Var(&c.gateThreshold, "gate-threshold", 0.01, "level below which --gate silences audio (0..1)")
+	flag.IntVar(&c.padStart, "pad-start", 0, "milliseconds of silence to prepend to the output")
+	flag.IntVar(&c.padEnd, "pad-end", 0, "milliseconds of silence to append to the output")
+	flag.BoolVar(&c.printDuration, "print-duration", false, "print the captured duration to stderr when recording completes")
+	flag.BoolVar(&c.abortOnClip, "abort-on-clip", false, "stop and exit non-zero if the input clips")
+	flag.IntVar(&c.clipLimit, "clip-limit", 0, "number of full-scale samples tolerated before --abort-on-clip fires")
+	flag.IntVar(&c.countdown, "countdown", 0, "play this many one-second ticks before recording starts")
+	flag.BoolVar(&c.base64, "base64", false, "base64-encode the WAV written to stdout")
+	flag.BoolVar(&c.resumeOnSignal, "resume-on-signal", false, "after each recording, wait for SIGHUP and record again into a new file")
+	flag.StringVar(&c.outputDir, "output-dir", ".", "directory for the timestamped files written by --resume-on-signal")
+	flag.Float64Var(&c.inputGainDB, "input-gain-db", 0, "gain in dB applied to the input before detection")
+	flag.BoolVar(&c.softClip, "soft-clip", false, "round off peaks that --input-gain-db or mixing push past full scale, instead of clipping them")
+	flag.BoolVar(&c.markers, "markers", false, "write cue markers where speech was detected to start and stop")
+	flag.BoolVar(&c.monitor, "monitor", false, "play the input back through the default output while recording")
+	flag.IntVar(&c.sidetoneDelay, "sidetone-delay", 0, "milliseconds to delay the --monitor signal by")
+	flag.StringVar(&c.hash, "hash", "", "print a hash of the recorded samples to stderr (md5, sha1, sha256 or sha512)")
+	flag.StringVar(&c.output, "output", "", "write the recording to this file instead of stdout")
+	flag.StringVar(&c.output, "o", "", "shorthand for --output")
+	flag.BoolVar(&c.wavExtensible, "wav-extensible", false, "write WAVE_FORMAT_EXTENSIBLE headers even for mono and stereo (always used above two channels)")
+	flag.StringVar(&c.format, "format", "", "output format: wav, aiff, caf or json-samples (debugging only) (default: from the --output extension, else wav)")
+	flag.BoolVar(&c.listHostApis, "list-host-apis", false, "list the available audio host APIs and exit")
+	flag.BoolVar(&c.listFormats, "list-formats", false, "list the rates, channel counts and sample types the --input-device, or the default input, supports and exit")
+	flag.Float64Var(&c.armTimeout, "arm-timeout", 0, "give up with exit status 3 if no speech is detected within this many seconds")
+	flag.BoolVar(&c.voiceFilter, "voice-filter", false, "band-pass the input to the speech band before detection and output")
+	flag.Float64Var(&c.voiceLow, "voice-filter-low", 80, "lower corner of --voice-filter in Hz")
+	flag.Float64Var(&c.voiceHigh, "voice-filter-high", 8000, "upper corner of --voice-filter in Hz (capped below Nyquist)")
+	flag.BoolVar(&c.append, "append", false, "add the recording to the end of the --output WAV file instead of replacing it")
+	flag.BoolVar(&c.convertOnAppend, "convert-on-append", false, "with --append, convert the recording to the existing file's channel count, rate and bit depth instead of refusing when they differ")
+	flag.StringVar(&c.logCSV, "log-csv", "", "append per-recording statistics to this CSV file")
+	flag.IntVar(&c.samples, "samples", 0, "record exactly this many samples, bypassing speech detection")
+	flag.IntVar(&c.rate, "rate", 16000, "sample rate to record at: 8000, 11025, 12000, 16000, 22050, 24000, 44100 or 48000")
+	flag.IntVar(&c.frameSize, "frame-size", 512, "number of samples read from the input at a time")
+	flag.Float64Var(&c.beepPan, "beep-pan", 0, "place the beeps in the stereo field, from -1 (left) to 1 (right); ignored on mono outputs")
+	flag.BoolVar(&c.autoDevice, "auto-device", false, "listen briefly to every input device and record from the one hearing the most")
+	flag.StringVar(&c.startCmd, "start-on-cmd", "", "run this shell command first and only start recording once it exits successfully")
+	flag.StringVar(&c.hotkey, "hotkey", "", "wait for a global key combination such as ctrl+alt+r, record while it is held and stop on release or silence (Linux, needs read access to /dev/input)")
+	flag.BoolVar(&c.replay, "replay", false, "play the recording back through the output before writing it")
+	flag.BoolVar(&c.removeBeep, "remove-beep", false, "find the start beep where it leaked into the recording from the speakers and silence it")
+	flag.BoolVar(&c.mixBeep, "mix-beep", false, "mix the start and stop beeps into the recording")
+	flag.IntVar(&c.bitDepth, "bit-depth", 16, "bits per output sample: 8, 16 or 32 (IEEE float, wav only)")
+	flag.IntVar(&c.reduceBits, "reduce-bits", 0, "keep only this many significant bits of each 16-bit sample, with dither, so that FLAC and the like compress the file better")
+	flag.StringVar(&c.mmapOutput, "mmap-output", "", "capture straight into this 16-bit WAV file through a memory map, for long recordings; the audio is written as captured, without processing")
+	flag.BoolVar(&c.pauseSilence, "pause-emits-silence", false, "while paused, send silence to --stream and --transcribe-cmd so that they keep real time; the recording itself still skips the pause")
+	flag.BoolVar(&c.stream, "stream", false, "write the audio to stdout as a WAV while it is captured, before any processing; --output still gets the finished file")
+	flag.IntVar(&c.flushInterval, "flush-interval", 0, "milliseconds of audio to gather before each write to --stream and --transcribe-cmd (default: every frame)")
+	flag.StringVar(&c.transcribeCmd, "transcribe-cmd", "", "shell command to stream the recording to while capturing; its output goes to stderr")
+	flag.BoolVar(&c.failOnSilence, "fail-on-silence", false, "exit with status 3 instead of writing the file if the input is entirely silent")
+	flag.IntVar(&c.warmupReads, "warmup-reads", 0, "discard this many frames after the stream opens, to let automatic gain settle before detection")
+	flag.IntVar(&c.muteFirstMs, "mute-first-ms", 20, "milliseconds to silence at the start of the stream, where devices often pop (0 to keep them)")
+	flag.BoolVar(&c.measureLatency, "measure-latency", false, "play the beep, listen for it on the input and print the round-trip latency, then exit")
+	flag.Var(&c.inputDevices, "input-device", "record from this device instead of the default; repeat to mix several, with an optional gain as NAME@DB")
+	flag.StringVar(&c.profile, "profile", "", "load a [profile.NAME] block from the config file, or a capture tuning preset: lowlatency, balanced or robust (explicit flags win)")
+	flag.StringVar(&c.configPath, "config", "", "config file of flag defaults and profiles (default: raus/config in the user config directory)")
+	flag.IntVar(&c.latency, "latency", 0, "suggested input latency in milliseconds; higher is more robust on slow hardware (default: the device's high-latency setting)")
+	flag.BoolVar(&c.realtime, "realtime", false, "raise the scheduling priority of the capture thread (may need CAP_SYS_NICE on Linux)")
+	flag.IntVar(&c.rotate, "rotate", 0, "record continuously into segment files in --output-dir, keeping only this many of the newest")
+	flag.Float64Var(&c.maxFileDuration, "max-file-duration", 0, "record continuously into files of this many seconds each in --output-dir, without a gap between them")
+	flag.Float64Var(&c.rotateSeconds, "rotate-seconds", 0, "with --rotate, cut segments at this length instead of on silence")
+	flag.BoolVar(&c.forceDefaultRate, "force-device-default-rate", false, "open the input at its own default rate and resample to --rate in software, instead of leaving it to the driver")
+	flag.BoolVar(&c.strictRate, "strict-rate", false, "fail if the input does not run at the requested sample rate, instead of recording at its actual rate")
+	flag.BoolVar(&c.noTerminate, "no-terminate-on-stop", false, "leave portaudio initialised on exit, for host APIs that are slow or hang when shut down")
+	flag.BoolVar(&c.keepGoing, "keep-going-on-error", false, "with --resume-on-signal, restart audio and carry on after a failed recording")
+	flag.IntVar(&c.maxErrors, "max-consecutive-errors", 5, "give up after this many failed recordings in a row with --keep-going-on-error")
+	flag.BoolVar(&c.stitch, "stitch", false, "with --resume-on-signal, also join all takes into one file when the session ends")
+	flag.IntVar(&c.crossfade, "crossfade", 0, "milliseconds to crossfade between takes joined by --stitch")
+	flag.BoolVar(&c.speechOnly, "speech-only", false, "cut the silences between words and keep only the speech, joined together")
+	flag.IntVar(&c.contextBefore, "context-before", speechPadMs, "with --speech-only, milliseconds of audio kept ahead of each stretch of speech so that onsets survive the cut")
+	flag.IntVar(&c.fadeMs, "fade-ms", 0, "fade out over this many milliseconds when silence ends the recording")
+	flag.BoolVar(&c.denoise, "denoise", false, "reduce steady background noise by spectral subtraction, using the noise heard while calibrating")
+	flag.Float64Var(&c.preemphasis, "preemphasis", 0, "apply pre-emphasis with this coefficient, typically 0.97, for speech recognition front-ends")
+	flag.StringVar(&c.metadata, "metadata", "", "write a JSON sidecar with capture timestamps for A/V sync to this file")
+	flag.StringVar(&c.vadTrace, "vad-trace", "", "write the detector's level, floor, state and start and stop events during the capture to this CSV file")
+	flag.StringVar(&c.envelope, "envelope", "", "write a 10ms amplitude envelope of the capture to this file (CSV if it ends in .csv, float32 otherwise)")
+	flag.BoolVar(&c.noArm, "no-arm", false, "treat capture as started right away and only listen for the silence that ends it")
+	flag.IntVar(&c.maxBytes, "max-bytes", 0, "stop once the recorded audio data reaches this many bytes")
+	flag.IntVar(&c.takes, "takes", 1, "record this many takes in a row and keep only the one with the best SNR")
+	flag.Float64Var(&c.statsInterval, "stats-interval", 0, "print the RMS, peak, elapsed time and estimated SNR every this many seconds of capture")
+	flag.Float64Var(&c.heartbeat, "heartbeat", 0, "log a timestamped line every this many seconds of capture, for headless use with --quiet")
+	flag.StringVar(&c.floorUnits, "floor-units", "linear", "units for the live noise floor: linear (0 to 1) or dbfs")
+	flag.StringVar(&c.eventFIFO, "event-fifo", "", "write the capture's state, level and floor as JSON lines to this named pipe, creating it if needed, for a GUI to follow")
+	flag.BoolVar(&c.tui, "tui", false, "show a full-screen view of the level and detector state, with keys to stop (q), pause (p) and cancel (c)")
+	flag.BoolVar(&c.quiet, "quiet", false, "do not show the live noise floor and waiting spinner")
+	flag.BoolVar(&c.adaptiveFloor, "adaptive-floor", false, "calibrate the noise floor at startup and let it track the room while there is no speech")
+	flag.StringVar(&c.channelGate, "channel-gate", "", "detect speech on each channel separately and record while it is heard on any or all of them, instead of on their mix")
+	flag.IntVar(&c.armSustain, "arm-sustain", 0, "milliseconds the level must stay above the threshold before speech counts as started, to ignore coughs and clicks")
+	flag.StringVar(&c.saveCalibration, "save-calibration", "", "write the noise floor measured while calibrating to this file")
+	flag.StringVar(&c.loadCalibration, "load-calibration", "", "skip calibrating and start from the noise floor in this file, as written by --save-calibration")
+	flag.Float64Var(&c.thresholdPercentile, "auto-threshold-percentile", 0, "start on speech once the level passes this percentile of the levels heard while calibrating, e.g. 95, instead of 1.5 times the floor")
+	flag.Float64Var(&c.floorDecay, "floor-decay", 10, "time constant in seconds for --adaptive-floor to follow the ambient level")
+	flag.IntVar(&c.silenceGrace, "silence-grace", 0, "milliseconds of silence to bridge before stopping, for pauses between sentences")
+	flag.IntVar(&c.channels, "channels", 1, "number of input channels to record")
+	flag.BoolVar(&c.splitChannels, "split-channels", false, "write each channel to its own mono file named after --output")
+	applyEnvDefaults()
+	flag.CommandLine.Parse(args)
+	applyProfile(&c)
+
+	if !slices.Contains(speechRates, c.rate) {
+		log.Fatalf("--rate must be one of %s Hz", formatRates(speechRates))
+	}
+	sampleRate = c.rate
+	if c.generateSilence < 0 {
+		log.Fatal("--generate-silence must not be negative")
+	}
+	if c.generateTone < 0 || c.generateTone >= float64(sampleRate)/2 {
+		log.Fatalf("--generate-tone must be below the Nyquist frequency of %d Hz", sampleRate/2)
+	}
+	if c.duration <= 0 {
+		log.Fatal("--duration must be positive")
+	}
+	if c.padStart < 0 || c.padEnd < 0 {
+		log.Fatal("--pad-start and --pad-end must not be negative")
+	}
+	if c.sidetoneDelay < 0 {
+		log.Fatal("--sidetone-delay must not be negative")
+	}
+	if c.bitDepth != 8 && c.bitDepth != 16 && c.bitDepth != 32 {
+		log.Fatal("--bit-depth must be 8, 16 or 32")
+	}
+	if c.beepPan < -1 || c.beepPan > 1 {
+		log.Fatal("--beep-pan must be between -1 and 1")
+	}
+	if c.reduceBits < 0 || c.reduceBits > 15 {
+		log.Fatal("--reduce-bits must be between 1 and 15")
+	}
+	if c.reduceBits > 0 && c.bitDepth != 16 {
+		log.Fatal("--reduce-bits works with 16-bit output")
+	}
+	if c.thresholdPercentile < 0 || c.thresholdPercentile > 100 {
+		log.Fatal("--auto-threshold-percentile must be between 0 and 100")
+	}
+	if c.floorDecay <= 0 {
+		log.Fatal("--floor-decay must be positive")
+	}
+	if c.rotate < 0 || c.rotateSeconds < 0 || c.maxFileDuration < 0 {
+		log.Fatal("--rotate, --rotate-seconds and --max-file-duration must not be negative")
+	}
+	if c.maxFileDuration > 0 {
+		// Fixed-length segments that are all kept, unless --rotate
+		// limits them too.
+		if c.rotateSeconds > 0 {
+			log.Fatal("--max-file-duration replaces --rotate-seconds")
+		}
+		c.rotateSeconds = c.maxFileDuration
+		if c.rotate == 0 {
+			c.rotate = math.MaxInt
+		}
+	}
+	if c.flushInterval < 0 {
+		log.Fatal("--flush-interval must not be negative")
+	}
+	if c.stream && (c.rotate > 0 || c.resumeOnSignal || c.takes > 1 || c.splitChannels) {
+		log.Fatal("--stream works with a single recording, not with --rotate, --resume-on-signal, --takes or --split-channels")
+	}
+	if (c.metadata != "" || c.envelope != "") && (c.rotate > 0 || c.resumeOnSignal) {
+		log.Fatal("--metadata and --envelope describe a single recording, and cannot be combined with --rotate, --max-file-duration or --resume-on-signal")
+	}
+	if c.mmapOutput != "" && (c.output != "" || c.inputFile != "" || c.splitChannels || c.takes > 1 || c.rotate > 0 || c.resumeOnSignal) {
+		log.Fatal("--mmap-output replaces --output for a single live recording, and cannot be combined with --input-file, --split-channels, --takes, --rotate or --resume-on-signal")
+	}
+	switch c.channelGate {
+	case "", "any", "all":
+	default:
+		log.Fatalf("unknown --channel-gate %q, want any or all", c.channelGate)
+	}
+	if c.armSustain < 0 {
+		log.Fatal("--arm-sustain must not be negative")
+	}
+	if c.saveCalibration != "" && c.loadCalibration != "" {
+		log.Fatal("--save-calibration and --load-calibration cannot be combined")
+	}
+	if c.hotkey != "" && (c.inputFile != "" || c.rotate > 0) {
+		log.Fatal("--hotkey works with live recordings, not with --input-file or --rotate")
+	}
+	if c.autoDevice && (len(c.inputDevices) > 0 || c.inputFile != "") {
+		log.Fatal("--auto-device picks the input itself, and cannot be combined with --input-device or --input-file")
+	}
+	if c.startCmd != "" && (c.inputFile != "" || c.rotate > 0) {
+		log.Fatal("--start-on-cmd works with live recordings, not with --input-file or --rotate")
+	}
+	if c.replay && (c.inputFile != "" || c.rotate > 0 || c.resumeOnSignal) {
+		log.Fatal("--replay works with a single live recording, not with --input-file, --rotate or --resume-on-signal")
+	}
+	if c.rotate > 0 && (c.inputFile != "" || c.resumeOnSignal || c.takes > 1) {
+		log.Fatal("--rotate cannot be combined with --input-file, --resume-on-signal or --takes")
+	}
+	if c.keepGoing && !c.resumeOnSignal {
+		log.Fatal("--keep-going-on-error needs --resume-on-signal")
+	}
+	if c.maxErrors < 1 {
+		log.Fatal("--max-consecutive-errors must be at least 1")
+	}
+	if c.stitch && !c.resumeOnSignal {
+		log.Fatal("--stitch needs --resume-on-signal")
+	}
+	if c.fadeMs < 0 {
+		log.Fatal("--fade-ms must not be negative")
+	}
+	if c.preemphasis < 0 || c.preemphasis >= 1 {
+		log.Fatal("--preemphasis must be at least 0 and below 1")
+	}
+	if c.crossfade < 0 {
+		log.Fatal("--crossfade must not be negative")
+	}
+	if c.warmupReads < 0 {
+		log.Fatal("--warmup-reads must not be negative")
+	}
+	if c.muteFirstMs < 0 {
+		log.Fatal("--mute-first-ms must not be negative")
+	}
+	if c.maxBytes < 0 {
+		log.Fatal("--max-bytes must not be negative")
+	}
+	if c.takes < 1 {
+		log.Fatal("--takes must be at least 1")
+	}
+	if c.takes > 1 && (c.inputFile != "" || c.resumeOnSignal) {
+		log.Fatal("--takes cannot be combined with --input-file or --resume-on-signal")
+	}
+	if c.heartbeat < 0 {
+		log.Fatal("--heartbeat must not be negative")
+	}
+	if c.statsInterval < 0 {
+		log.Fatal("--stats-interval must not be negative")
+	}
+	if c.latency < 0 {
+		log.Fatal("--latency must not be negative")
+	}
+	if c.contextBefore < 0 {
+		log.Fatal("--context-before must not be negative")
+	}
+	if c.channels < 1 {
+		log.Fatal("--channels must be at least 1")
+	}
+	if c.splitChannels && c.channels < 2 {
+		log.Fatal("--split-channels needs --channels 2 or more")
+	}
+	if c.splitChannels && c.output == "" {
+		log.Fatal("--split-channels needs --output to name the per-channel files")
+	}
+	if c.frameSize <= 0 {
+		log.Fatal("--frame-size must be positive")
+	}
+	if c.samples < 0 {
+		log.Fatal("--samples must not be negative")
+	}
+	if c.samples > 0 && (c.armTimeout > 0 || c.markers) {
+		log.Print("--samples bypasses speech detection, so --arm-timeout and --markers have no effect")
+		c.armTimeout = 0
+	}
+	if c.noArm && c.armTimeout > 0 {
+		log.Print("--no-arm never waits for speech, so --arm-timeout has no effect")
+		c.armTimeout = 0
+	}
+	if c.voiceLow <= 0 || c.voiceHigh <= c.voiceLow {
+		log.Fatal("--voice-filter-low must be positive and below --voice-filter-high")
+	}
+	if c.hash != "" && newHash(c.hash) == nil {
+		log.Fatalf("unsupported --hash algorithm %q", c.hash)
+	}
+
+	if c.floorUnits != "linear" && c.floorUnits != "dbfs" {
+		log.Fatalf("unknown --floor-units %q, want linear or dbfs", c.floorUnits)
+	}
+
+	if c.format == "" {
+		c.format = formatFromExtension(c.output)
+	}
+	switch c.format {
+	case "wav", "aiff", "caf", "json-samples":
+	case "flac", "opus", "mp3", "webm", "ogg":
+		log.Fatalf("%s output is not supported yet; see the README for piping --stream into an encoder", c.format)
+	default:
+		log.Fatalf("unknown --format %q", c.format)
+	}
+	if c.append && (c.output == "" || c.format != "wav" || c.splitChannels || c.base64) {
+		log.Fatal("--append needs a wav --output, and cannot be combined with --split-channels or --base64")
+	}
+	if c.convertOnAppend && !c.append {
+		log.Fatal("--convert-on-append needs --append")
+	}
+	if c.bitDepth == 32 && (c.format == "aiff" || c.format == "caf") {
+		log.Fatal("--bit-depth 32 is only supported for wav output")
+	}
+	if c.mmapOutput != "" {
+		for _, f := range []struct {
+			set  bool
+			name string
+		}{
+			{c.bitDepth != 16, "--bit-depth"},
+			{c.format != "wav", "--format"},
+			{c.base64, "--base64"},
+			{c.hash != "", "--hash"},
+			{c.bwf, "--bwf"},
+			{c.markers, "--markers"},
+			{c.removeBeep, "--remove-beep"},
+			{c.mixBeep, "--mix-beep"},
+			{c.denoise, "--denoise"},
+			{c.preemphasis > 0, "--preemphasis"},
+			{c.gate, "--gate"},
+			{c.speechOnly, "--speech-only"},
+			{c.fadeMs > 0, "--fade-ms"},
+			{c.padStart > 0 || c.padEnd > 0, "--pad-start and --pad-end"},
+			{c.reduceBits > 0, "--reduce-bits"},
+		} {
+			if f.set {
+				log.Fatalf("--mmap-output keeps the audio as captured, in a 16-bit wav, so it cannot be combined with %s", f.name)
+			}
+		}
+	}
+	return c
+}
+
+// applyEnvDefaults presets every flag from its RAUS_* environment variable
+// (e.g. --pad-start from RAUS_PAD_START). Flags given on the command line
+// still take precedence since they are parsed afterwards.
+func applyEnvDefaults() {
+	flag.VisitAll(func(f *flag.Flag) {
+		name := "RAUS_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		// flag.Set, unlike f.Value.Set, records the flag as given so that
+		// --profile does not override it.
+		err := flag.Set(f.Name, value)
+		if err != nil {
+			log.Fatalf("invalid value %q for %s: %v", value, name, err)
+		}
+	})
 }
 
 func main() {
-	portaudio.Initialize()
-	defer portaudio.Terminate()
+	name, args := subcommand(os.Args[1:])
+	switch name {
+	case "devices":
+		runDevices(args)
+	case "repair":
+		runRepair(args)
+	case "test":
+		runTest(args)
+	case "vad-bench":
+		runVADBench(args)
+	default:
+		runRecord(args)
+	}
+}
+
+// runRecord is the record subcommand, and what raus does when no
+// subcommand is given.
+func runRecord(args []string) {
+	cfg := parseFlags(args)
+	noArgs()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGHUP)
+
+	if cfg.generateSilence > 0 {
+		rec := generatedRecording(generateSilence(cfg.generateSilence, cfg), cfg)
+		err := writeGenerated(rec, cfg)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if cfg.generateTone > 0 {
+		samples := generateSilence(cfg.duration, cfg)
+		mixInto(samples, cfg.channels, generateTone(cfg.generateTone, cfg.duration), 0)
+		err := writeGenerated(generatedRecording(samples, cfg), cfg)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if cfg.inputFile != "" {
+		src, err := openFileSource(cfg.inputFile, cfg.captureFormat(), cfg.frameSize)
+		if err != nil {
+			fatal(err)
+		}
+		rec, err := recordAudioWithDynamicNoiseFloor(src, cfg, stop, nil)
+		if err != nil {
+			fatal(err)
+		}
+		err = writeRecording(rec, cfg)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
 
-	beep := generateBeep()
+	initAudio()
+	if !cfg.noTerminate {
+		defer terminateAudio()
+	}
+
+	if cfg.listHostApis {
+		err := listHostApis()
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if cfg.listFormats {
+		err := listFormats(cfg)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if cfg.measureLatency {
+		d, err := measureLatency(cfg)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Round-trip latency: %.1f ms\n", d.Seconds()*1000)
+		return
+	}
+
+	if cfg.realtime {
+		// Capture runs on this goroutine; keep it on the thread whose
+		// priority gets raised.
+		runtime.LockOSThread()
+		err := raisePriority()
+		if err != nil {
+			log.Print(err)
+		}
+	}
+
+	if cfg.autoDevice {
+		fmt.Fprintf(os.Stderr, "Probing input devices...\n")
+		name, err := loudestInput(cfg)
+		if err != nil {
+			fatal(err)
+		}
+		cfg.inputDevices = deviceList{{name: name}}
+	}
+
+	if cfg.rotate > 0 {
+		err := recordRotating(cfg)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if cfg.resumeOnSignal {
+		err := recordRepeatedly(cfg)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	var rec *recording
+	var err error
+	if cfg.takes > 1 {
+		rec, err = recordBestTake(cfg, stop)
+	} else {
+		rec, err = recordLive(cfg, stop)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if cfg.replay {
+		err = replayRecording(rec, cfg.frameSize)
+		if err != nil {
+			log.Printf("could not replay the recording: %v", err)
+		}
+	}
+	if m, ok := rec.audio.(*mmapOutput); ok {
+		err = finishMmapOutput(m, rec, cfg)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+	err = writeRecording(rec, cfg)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// recordLive takes one recording from the microphone, framed by the start
+// and stop beeps. It starts once --start-on-cmd has succeeded, and with
+// --hotkey it waits for the combination first and stops when it is let go.
+func recordLive(cfg config, stop <-chan os.Signal) (*recording, error) {
+	if cfg.startCmd != "" {
+		fmt.Fprintf(os.Stderr, "Waiting for %q to finish.\n", cfg.startCmd)
+		err := waitForCommand(cfg.startCmd, stop)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var release <-chan struct{}
+	if cfg.hotkey != "" {
+		h, err := openHotkey(cfg.hotkey)
+		if err != nil {
+			return nil, err
+		}
+		defer h.Close()
+		fmt.Fprintf(os.Stderr, "Hold %s to record.\n", cfg.hotkey)
+		select {
+		case <-h.pressed:
+		case <-stop:
+			return nil, errCancelled
+		}
+		release = h.released
+	}
+
+	beep := generateBeep(beepDuration)
+	playCountdown(cfg.countdown, cfg.beepPan)
+
+	warnSelection(cfg)
+	fmt.Fprintf(os.Stderr, "Recording from %s (%d Hz, %d ch, %d-bit %s)...\n",
+		inputName(cfg), sampleRate, cfg.channels, cfg.bitDepth, cfg.format)
+	playBeep(beep, cfg.beepPan)
+	src, err := openInput(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Fprintf(os.Stderr, "Recording...\n")
-	playBeep(beep)
-	audioBuffer := recordAudioWithDynamicNoiseFloor()
-	playBeep(beep)
+	rec, err := recordAudioWithDynamicNoiseFloor(src, cfg, stop, release)
+	if err != nil {
+		return nil, err
+	}
+
+	playBeep(beep, cfg.beepPan)
 	fmt.Fprintf(os.Stderr, "Recording completed.\n")
 
-	header := createWAVHeader(uint32(audioBuffer.Len()))
+	data := rec.audio.Bytes()
+	if float64(countNonzeroPCM(data)) < float64(len(data)/2)*minNonzeroFraction {
+		if cfg.failOnSilence {
+			if m, ok := rec.audio.(*mmapOutput); ok {
+				abandonMmapOutput(m, errSilentInput, cfg)
+			}
+			return nil, errSilentInput
+		}
+		log.Print(errSilentInput)
+	}
+	return rec, nil
+}
+
+// recordBestTake records --takes takes in a row and returns the one with
+// the highest SNR, falling back to the loudest if none detected speech.
+func recordBestTake(cfg config, stop <-chan os.Signal) (*recording, error) {
+	var best *recording
+	var bestPeak float64
+	for i := range cfg.takes {
+		fmt.Fprintf(os.Stderr, "Take %d of %d\n", i+1, cfg.takes)
+		rec, err := recordLive(cfg, stop)
+		if err != nil {
+			return nil, err
+		}
+
+		peak := peakLevel(decodeSamples(rec.audio.Bytes()))
+		fmt.Fprintf(os.Stderr, "Take %d: SNR %.1f dB, peak %.4f\n", i+1, rec.snr(), peak)
+		if best == nil || betterTake(rec, peak, best, bestPeak) {
+			best, bestPeak = rec, peak
+		}
+	}
+	return best, nil
+}
+
+// betterTake reports whether a beats b, preferring SNR and using the peak
+// level when either SNR is unknown.
+func betterTake(a *recording, aPeak float64, b *recording, bPeak float64) bool {
+	aSNR, bSNR := a.snr(), b.snr()
+	if math.IsNaN(aSNR) || math.IsNaN(bSNR) {
+		if math.IsNaN(aSNR) != math.IsNaN(bSNR) {
+			return math.IsNaN(bSNR)
+		}
+		return aPeak > bPeak
+	}
+	return aSNR > bSNR
+}
+
+// recordRepeatedly keeps portaudio initialised and records take after take,
+// each into its own timestamped file. SIGHUP stops the current take or
+// starts the next one, SIGQUIT throws the current take away, and SIGINT
+// and SIGTERM end the session.
+func recordRepeatedly(cfg config) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+
+	var takes []*recording
+	if cfg.stitch {
+		defer func() {
+			err := writeStitched(takes, cfg)
+			if err != nil {
+				log.Printf("could not stitch takes: %v", err)
+			}
+		}()
+	}
+
+	var failures int
+	for {
+		rec, err := recordLive(cfg, signals)
+		if errors.Is(err, errCancelled) {
+			fmt.Fprintf(os.Stderr, "Waiting for SIGHUP to start the next recording.\n")
+			if sig := <-signals; sig != syscall.SIGHUP {
+				return nil
+			}
+			continue
+		}
+		if err != nil {
+			if !cfg.keepGoing {
+				return err
+			}
+			failures++
+			if failures >= cfg.maxErrors {
+				return fmt.Errorf("giving up after %d consecutive errors: %w", failures, err)
+			}
+			log.Printf("recording failed (%v), restarting audio", err)
+			terminateAudio()
+			initAudio()
+
+			// Back off briefly so a device that is gone does not spin.
+			select {
+			case sig := <-signals:
+				if sig != syscall.SIGHUP {
+					return nil
+				}
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		failures = 0
+		takes = append(takes, rec)
+
+		err = reportRecording(rec, cfg)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(cfg.outputDir, rec.start.Format("raus-20060102-150405.")+cfg.format)
+		err = writeFile(path, rec, cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Saved %s\n", path)
+
+		if rec.stopSignal == syscall.SIGINT || rec.stopSignal == syscall.SIGTERM {
+			return nil
+		}
+
+		fmt.Fprintf(os.Stderr, "Waiting for SIGHUP to start the next recording.\n")
+		if sig := <-signals; sig != syscall.SIGHUP {
+			return nil
+		}
+	}
+}
+
+// writeStitched joins takes into one file, crossfading between them, and
+// saves it to --output or to a timestamped file in --output-dir.
+func writeStitched(takes []*recording, cfg config) error {
+	if len(takes) == 0 {
+		return nil
+	}
+
+	first := takes[0]
+	segments := make([][]int16, len(takes))
+	for i, take := range takes {
+		segments[i] = decodeSamples(take.audio.Bytes())
+	}
+	samples := crossfade(segments, first.format.channels, msToSamples(cfg.crossfade))
 
-	err := binary.Write(os.Stdout, binary.LittleEndian, header)
+	stitched := &recording{
+		audio:      bytes.NewBuffer(encodeSamples(samples)),
+		format:     first.format,
+		start:      first.start,
+		stopReason: takes[len(takes)-1].stopReason,
+	}
+	path := cfg.output
+	if path == "" {
+		path = filepath.Join(cfg.outputDir, first.start.Format("raus-20060102-150405-stitched.")+cfg.format)
+	}
+	err := writeFile(path, stitched, cfg)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	fmt.Fprintf(os.Stderr, "Saved %d takes to %s\n", len(takes), path)
+	return nil
+}
 
-	_, err = io.Copy(os.Stdout, audioBuffer)
+// finishMmapOutput completes a recording made with --mmap-output, reporting
+// on it and writing the sidecars that need only the recording's
+// measurements. The file is closed even if they fail.
+func finishMmapOutput(m *mmapOutput, rec *recording, cfg config) error {
+	err := reportRecording(rec, cfg)
+	if err == nil && cfg.metadata != "" {
+		err = writeMetadata(cfg.metadata, rec)
+	}
+	if cerr := m.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	fmt.Fprintf(os.Stderr, "Saved %s\n", cfg.mmapOutput)
+	return nil
 }
 
-func createWAVHeader(dataSize uint32) wavHeader {
-	return wavHeader{
-		ChunkID:       [4]byte{'R', 'I', 'F', 'F'},
-		ChunkSize:     36 + dataSize,
-		Format:        [4]byte{'W', 'A', 'V', 'E'},
-		Subchunk1ID:   [4]byte{'f', 'm', 't', ' '},
-		Subchunk1Size: 16,
-		AudioFormat:   1,
-		NumChannels:   1,
-		SampleRate:    sampleRate,
-		ByteRate:      sampleRate * 2,
-		BlockAlign:    2,
-		BitsPerSample: 16,
-		Subchunk2ID:   [4]byte{'d', 'a', 't', 'a'},
-		Subchunk2Size: dataSize,
+// abandonMmapOutput closes the file of an --mmap-output recording that
+// failed with err, leaving the audio captured before the failure as a
+// valid WAV. A cancelled recording is removed instead.
+func abandonMmapOutput(m *mmapOutput, err error, cfg config) {
+	cerr := m.Close()
+	if errors.Is(err, errCancelled) {
+		cerr = os.Remove(cfg.mmapOutput)
+	}
+	if cerr != nil {
+		log.Printf("could not close %s: %v", cfg.mmapOutput, cerr)
 	}
 }
 
-func recordAudioWithDynamicNoiseFloor() *bytes.Buffer {
-	audioBuffer := &bytes.Buffer{}
-	in := make([]int16, 512)
-	stream, err := portaudio.OpenDefaultStream(1, 0, sampleRate, len(in), in)
+// writeRecording writes rec to --output, or to stdout if none was given.
+func writeRecording(rec *recording, cfg config) error {
+	if cfg.metadata != "" {
+		err := writeMetadata(cfg.metadata, rec)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.envelope != "" {
+		env := computeEnvelope(decodeSamples(rec.audio.Bytes()), rec.format.channels)
+		err := writeEnvelope(cfg.envelope, env)
+		if err != nil {
+			return err
+		}
+	}
+	err := reportRecording(rec, cfg)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer stream.Close()
+	if cfg.splitChannels {
+		return writeSplitChannels(rec, cfg)
+	}
+	if cfg.output == "" {
+		if cfg.stream {
+			return nil // it has already gone out while recording
+		}
+		return writeOutput(os.Stdout, "", rec, cfg)
+	}
+	if cfg.append {
+		return appendFile(cfg.output, rec, cfg)
+	}
+	return writeFile(cfg.output, rec, cfg)
+}
 
-	err = stream.Start()
+// writeSplitChannels writes each channel of rec to its own mono file,
+// named by substituting the channel number for {channel} in --output, or
+// by adding a -chN suffix if there is no such token.
+func writeSplitChannels(rec *recording, cfg config) error {
+	channels := splitChannels(decodeSamples(rec.audio.Bytes()), rec.format.channels)
+	for i, samples := range channels {
+		mono := *rec
+		mono.audio = bytes.NewBuffer(encodeSamples(samples))
+		mono.format.channels = 1
+
+		err := writeFile(channelPath(cfg.output, i+1), &mono, cfg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func channelPath(template string, channel int) string {
+	n := strconv.Itoa(channel)
+	if strings.Contains(template, "{channel}") {
+		return strings.ReplaceAll(template, "{channel}", n)
+	}
+	ext := filepath.Ext(template)
+	return strings.TrimSuffix(template, ext) + "-ch" + n + ext
+}
+
+func writeFile(path string, rec *recording, cfg config) error {
+	f, err := os.Create(path)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	err = writeOutput(f, path, rec, cfg)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// reportRecording prints what was measured of rec and adds it to
+// --log-csv. It is done once per recording, however many files it is
+// written to.
+func reportRecording(rec *recording, cfg config) error {
+	if snr := rec.snr(); !math.IsNaN(snr) {
+		fmt.Fprintf(os.Stderr, "Estimated SNR: %.1f dB\n", snr)
+	}
+	if cfg.printDuration {
+		fmt.Fprintf(os.Stderr, "Duration: %.2fs\n", rec.format.duration(rec.audio.Len()).Seconds())
 	}
+	if cfg.logCSV != "" {
+		return appendCSVLog(cfg.logCSV, rec, decodeSamples(rec.audio.Bytes()))
+	}
+	return nil
+}
 
-	var noiseFloor float64
-	var maxNoiseFloor float64
+// writeOutput applies any post-processing to the recording and writes the
+// result to w in the configured format. The --hash of the audio as written
+// is labelled with name, the file it goes to, if there is one.
+func writeOutput(w io.Writer, name string, rec *recording, cfg config) error {
+	samples := decodeSamples(rec.audio.Bytes())
+	channels := rec.format.channels
+	if cfg.removeBeep {
+		removeBeep(samples, channels)
+	}
+	if cfg.denoise {
+		denoise(samples, channels, rec.format.sampleRate)
+	}
+	if cfg.preemphasis > 0 {
+		applyPreemphasis(samples, channels, cfg.preemphasis)
+	}
+	if cfg.gate {
+		applyGate(samples, channels, cfg.gateThreshold)
+	}
+	if cfg.speechOnly {
+		samples = keepSpeech(samples, channels, cfg.contextBefore)
+	}
+	if cfg.fadeMs > 0 && rec.stopReason == "silence" {
+		// Whatever ended the recording may have been a transient, so
+		// don't cut off on it.
+		fadeOut(samples, channels, msToSamples(cfg.fadeMs))
+	}
+	if cfg.mixBeep {
+		// The beeps are played just outside the capture, so place them
+		// at its very start and end.
+		beep := generateBeep(beepDuration)
+		mixInto(samples, channels, beep, 0)
+		mixInto(samples, channels, beep, len(samples)/channels-len(beep))
+	}
+	samples = padSilence(samples, msToSamples(cfg.padStart)*channels, msToSamples(cfg.padEnd)*channels)
+	if cfg.reduceBits > 0 {
+		reduceBits(samples, cfg.reduceBits)
+	}
+
+	var extra []wavChunk
+	if cfg.bwf {
+		extra = append(extra, createBextChunk(rec))
+	}
+	markers := slices.Concat(rec.bookmarks, rec.overflows)
+	if cfg.markers {
+		markers = append(markers, rec.markers...)
+	}
+	if len(markers) > 0 && !cfg.speechOnly { // cutting out silence moves them
+		shifted := make([]marker, len(markers))
+		for i, m := range markers {
+			shifted[i] = marker{m.offset + msToSamples(cfg.padStart), m.label}
+		}
+		sort.Slice(shifted, func(i, j int) bool { return shifted[i].offset < shifted[j].offset })
+		extra = append(extra, createCueChunks(shifted)...)
+	}
+
+	var encoder io.WriteCloser
+	if cfg.base64 {
+		encoder = base64.NewEncoder(base64.StdEncoding, w)
+		w = encoder
+	}
+
+	format := rec.format
+	format.bitDepth = cfg.bitDepth
+	format.extensible = cfg.wavExtensible || format.channels > 2
+	var data []byte
+	switch cfg.format {
+	case "aiff", "caf":
+		data = encodeBigEndianPCM(samples, format.bitDepth)
+	case "json-samples":
+		var text bytes.Buffer
+		writeJSONSamples(&text, samples)
+		data = text.Bytes()
+	default:
+		data = encodePCM(samples, format.bitDepth)
+	}
+	if cfg.hash != "" {
+		h := newHash(cfg.hash)
+		h.Write(data)
+		if name != "" {
+			fmt.Fprintf(os.Stderr, "%s (%s): %x\n", cfg.hash, name, h.Sum(nil))
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %x\n", cfg.hash, h.Sum(nil))
+		}
+	}
+
+	var err error
+	switch cfg.format {
+	case "aiff":
+		err = writeAIFF(w, format, data)
+	case "caf":
+		err = writeCAF(w, format, data)
+	case "json-samples":
+		_, err = w.Write(data)
+	default:
+		err = writeWAV(w, format, data, extra...)
+	}
+	if err == nil && encoder != nil {
+		err = encoder.Close() // flush the final partial block
+	}
+	return err
+}
+
+// recording is the result of a capture along with what the detector
+// measured while taking it.
+type recording struct {
+	audio        pcmData
+	format       pcmFormat
+	start        time.Time
+	stopSignal   os.Signal // signal that ended the recording, if any
+	ambientFloor float64   // level when speech was first detected
+	peakFloor    float64   // highest level seen after that
+	markers      []marker  // where speech started and stopped
+	bookmarks    []marker  // dropped with SIGUSR2
+	overflows    []marker  // where the input overflowed and audio was lost
+	stopReason   string
+	streamStart  time.Time // when the input stream started, for live capture
+	streamClock  float64   // portaudio's stream time at that moment
+	firstAudio   int       // frame of the first nonzero sample, or -1
+}
+
+// pcmData holds captured audio as little-endian 16-bit PCM, in memory or,
+// with --mmap-output, in a mapped file.
+type pcmData interface {
+	io.Writer
+	Len() int
+	Bytes() []byte
+}
+
+// snr estimates the signal-to-noise ratio in dB as the peak level over the
+// ambient floor. It returns NaN if speech was never detected.
+func (r *recording) snr() float64 {
+	if r.ambientFloor == 0 || r.peakFloor == 0 {
+		return math.NaN()
+	}
+	return 20 * math.Log10(r.peakFloor/r.ambientFloor)
+}
+
+// initialBufferSeconds is how much audio the capture buffer holds before
+// its first reallocation when the length of the recording is not bounded.
+const initialBufferSeconds = 30
+
+// bufferCapacity returns the capture buffer size for a recording of at most
+// maxFrames frames, where 0 means unbounded.
+func bufferCapacity(cfg config, maxFrames int) int {
+	frames := initialBufferSeconds * sampleRate
+	if maxFrames > 0 {
+		frames = maxFrames
+	}
+	return frames * cfg.captureFormat().blockAlign()
+}
+
+func recordAudioWithDynamicNoiseFloor(src audioSource, cfg config, stop <-chan os.Signal, release <-chan struct{}) (rec *recording, err error) {
+	// --max-bytes limits the audio data as written, at the output bit depth.
+	maxFrames := cfg.maxBytes / (cfg.channels * cfg.bitDepth / 8)
+	limit := maxFrames
+	if cfg.samples > 0 && (limit == 0 || cfg.samples < limit) {
+		limit = cfg.samples
+	}
+	var audioBuffer pcmData
+	if cfg.mmapOutput != "" {
+		format := cfg.captureFormat()
+		format.extensible = cfg.wavExtensible
+		m, merr := createMmapOutput(cfg.mmapOutput, format, bufferCapacity(cfg, limit))
+		if merr != nil {
+			return nil, merr
+		}
+		// A finished recording is closed by finishMmapOutput, once it
+		// has been reported on; one that fails is closed here.
+		defer func() {
+			if err != nil {
+				abandonMmapOutput(m, err, cfg)
+			}
+		}()
+		audioBuffer = m
+	} else {
+		audioBuffer = bytes.NewBuffer(make([]byte, 0, bufferCapacity(cfg, limit)))
+	}
+	rec = &recording{audio: audioBuffer, format: cfg.captureFormat(), start: time.Now(), firstAudio: -1}
+	rec.format.sampleRate = src.Rate()
+	if s, ok := src.(*streamSource); ok {
+		rec.streamStart = s.started
+		rec.streamClock = s.clock
+	}
+	defer src.Close()
+
+	gain := dbToGain(cfg.inputGainDB)
+	var filters channelFilters
+	if cfg.voiceFilter {
+		for range cfg.channels {
+			filters = append(filters, newVoiceFilter(cfg.voiceLow, cfg.voiceHigh, rec.format.sampleRate))
+		}
+	}
+	vad := newDetector(cfg, rec.format.sampleRate)
+	if cfg.loadCalibration != "" {
+		c, err := loadCalibration(cfg.loadCalibration)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.thresholdPercentile > 0 && c.Threshold == 0 {
+			return nil, fmt.Errorf("%s was saved without --auto-threshold-percentile", cfg.loadCalibration)
+		}
+		vad.calibrate(c)
+	}
+	var trace *vadTrace
+	if cfg.vadTrace != "" {
+		trace = newVADTrace(rec.format.sampleRate)
+		// Written however the capture ends, since a recording that did
+		// not start or stop as expected is what it is for.
+		defer func() {
+			err := trace.write(cfg.vadTrace)
+			if err != nil {
+				log.Printf("could not write the VAD trace: %v", err)
+			}
+		}()
+	}
+	var clippedSamples int
 	var sampleCount int
-	var recordingStarted bool
-	var silenceCount int
-	window := make([]float64, windowSize)
+	var frames int
 
-	// Set up signal handling
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGHUP)
+	rate := rec.format.sampleRate
+	armTimeout := int(cfg.armTimeout * float64(rate))
+	heartbeat := int(cfg.heartbeat * float64(rate))
+	nextHeartbeat := heartbeat
+	var stats captureStats
+	statsInterval := int(cfg.statsInterval * float64(rate))
+	nextStats := statsInterval
 
-	// Create a channel to signal when to stop recording
-	stopChan := make(chan struct{})
+	var sinks []io.Writer
+	if cfg.transcribeCmd != "" {
+		t, err := startTranscriber(cfg.transcribeCmd, rec.format)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			err := t.Close()
+			if err != nil {
+				log.Printf("transcribe command: %v", err)
+			}
+		}()
+		sinks = append(sinks, t)
+	}
+	if cfg.stream {
+		err := writeStreamingWAVHeader(os.Stdout, rec.format)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, os.Stdout)
+	}
+	var sink io.Writer
+	var pending []int16 // audio held back until --flush-interval has passed
+	flushFrames := msToSamples(cfg.flushInterval)
+	if len(sinks) > 0 {
+		sink = io.MultiWriter(sinks...)
+		// Runs before the transcriber is closed, so it gets the tail.
+		defer func() {
+			if len(pending) > 0 {
+				binary.Write(sink, binary.LittleEndian, pending)
+			}
+		}()
+	}
+	// emit passes samples on to the sinks, --flush-interval at a time.
+	emit := func(samples []int16) error {
+		if sink == nil {
+			return nil
+		}
+		pending = append(pending, samples...)
+		if len(pending)/cfg.channels < flushFrames {
+			return nil
+		}
+		err := binary.Write(sink, binary.LittleEndian, pending)
+		pending = pending[:0]
+		return err
+	}
 
-	// Start a goroutine to handle the SIGHUP signal
-	go func() {
-		<-sigChan
-		fmt.Fprintf(os.Stderr, "\nReceived SIGHUP, stopping recording.\n")
-		close(stopChan)
-	}()
+	bookmarks := make(chan os.Signal, 1)
+	notifyBookmark(bookmarks)
+	defer signal.Stop(bookmarks)
+	cancel := make(chan os.Signal, 1)
+	notifyCancel(cancel)
+	defer signal.Stop(cancel)
+	pause := make(chan os.Signal, 1)
+	notifyPause(pause)
+	defer signal.Stop(pause)
+
+	var ui *tui
+	var keys <-chan byte
+	if cfg.tui {
+		var err error
+		ui, err = startTUI("raus: " + inputName(cfg))
+		if err != nil {
+			return nil, err
+		}
+		defer ui.Close()
+		keys = ui.keys
+	}
+	var events *eventWriter
+	if cfg.eventFIFO != "" {
+		var err error
+		events, err = openEvents(cfg.eventFIFO)
+		if err != nil {
+			return nil, err
+		}
+		defer events.Close()
+		defer func() { events.stop(rec.stopReason) }()
+	}
+	paused := false
 
 	for {
+		if armTimeout > 0 && !vad.started && sampleCount >= armTimeout {
+			return rec, errNoSignal
+		}
+
 		select {
-		case <-stopChan:
-			return audioBuffer
+		case sig := <-stop:
+			fmt.Fprintf(os.Stderr, "\nReceived %s, stopping recording.\n", signalName(sig))
+			rec.stopSignal = sig
+			rec.stopReason = signalName(sig)
+			return rec, nil
+		case <-release:
+			fmt.Fprintf(os.Stderr, "\nReleased %s, stopping recording.\n", cfg.hotkey)
+			rec.stopReason = "hotkey"
+			return rec, nil
+		case <-bookmarks:
+			fmt.Fprintf(os.Stderr, "\nBookmark at %.2fs\n", float64(sampleCount)/float64(rate))
+			rec.bookmarks = append(rec.bookmarks, marker{sampleCount, "bookmark"})
+		case <-cancel:
+			fmt.Fprintf(os.Stderr, "\nReceived SIGQUIT, discarding the recording.\n")
+			return nil, errCancelled
+		case <-pause:
+			paused = !paused
+			if ui == nil && paused {
+				fmt.Fprintf(os.Stderr, "\nReceived SIGUSR1, pausing.\n")
+			} else if ui == nil {
+				fmt.Fprintf(os.Stderr, "\nReceived SIGUSR1, resuming.\n")
+			}
+		case key := <-keys:
+			switch key {
+			case 'q', '\n', '\r':
+				rec.stopReason = "key"
+				return rec, nil
+			case 'p', ' ':
+				paused = !paused
+			case 'c':
+				return nil, errCancelled
+			}
 		default:
-			err = stream.Read()
+			in, err := src.Read()
+			if err == io.EOF {
+				rec.stopReason = "end of input"
+				return rec, nil
+			}
+			if err == portaudio.InputOverflowed {
+				// The frame itself is intact; what was lost came before it.
+				fmt.Fprintf(os.Stderr, "\nInput overflow at %.2fs\n", float64(sampleCount)/float64(rate))
+				rec.overflows = append(rec.overflows, marker{sampleCount, "overflow"})
+				err = nil
+			}
 			if err != nil {
-				log.Fatal(err)
+				return nil, err
+			}
+			if paused {
+				// Keep reading so that the input does not overflow, but
+				// drop the audio.
+				if cfg.pauseSilence {
+					clear(in)
+					err = emit(in)
+					if err != nil {
+						return nil, err
+					}
+				}
+				st := captureStatus(vad, audioBuffer.Len()/rec.format.blockAlign(), rec.format.sampleRate, true)
+				if ui != nil {
+					ui.draw(st)
+				}
+				if events != nil {
+					events.send(st)
+				}
+				continue
+			}
+			if cfg.samples > 0 {
+				n := min(len(in), (cfg.samples-sampleCount)*cfg.channels)
+				if s, ok := src.(*seamSource); ok {
+					s.unread(in[n:])
+				}
+				in = in[:n]
+			}
+			if gain != 1 {
+				applyGain(in, gain, cfg.softClip)
+			}
+			filters.apply(in)
+			if maxFrames > 0 {
+				captured := audioBuffer.Len() / rec.format.blockAlign()
+				in = in[:min(len(in), (maxFrames-captured)*cfg.channels)]
 			}
 
 			err = binary.Write(audioBuffer, binary.LittleEndian, in)
 			if err != nil {
-				log.Fatal(err)
+				return nil, err
+			}
+			err = emit(in)
+			if err != nil {
+				return nil, err
 			}
 
-			for _, sample := range in {
-				amplitude := math.Abs(float64(sample)) / math.MaxInt16
-				window[sampleCount%windowSize] = amplitude
-				sampleCount++
+			if rec.firstAudio < 0 {
+				captured := audioBuffer.Len()/rec.format.blockAlign() - len(in)/cfg.channels
+				for i, s := range in {
+					if s != 0 {
+						rec.firstAudio = captured + i/cfg.channels
+						break
+					}
+				}
+			}
+
+			if maxFrames > 0 && audioBuffer.Len()/rec.format.blockAlign() >= maxFrames {
+				fmt.Fprintf(os.Stderr, "\nReached --max-bytes, stopping recording.\n")
+				rec.stopReason = "size limit"
+				return rec, nil
+			}
+
+			if heartbeat > 0 {
+				captured := audioBuffer.Len() / rec.format.blockAlign()
+				if captured >= nextHeartbeat {
+					log.Printf("recording, %ds captured", captured/rate)
+					nextHeartbeat = (captured/heartbeat + 1) * heartbeat
+				}
+			}
+
+			if statsInterval > 0 {
+				stats.add(in)
+				captured := audioBuffer.Len() / rec.format.blockAlign()
+				if captured >= nextStats {
+					stats.report(time.Duration(captured)*time.Second/time.Duration(rec.format.sampleRate), rec.snr())
+					nextStats = (captured/statsInterval + 1) * statsInterval
+				}
+			}
 
-				if sampleCount >= windowSize {
-					currentNoiseFloor := calculateAverage(window)
-					fmt.Fprintf(os.Stderr, "Current noise floor: %.4f\r", currentNoiseFloor)
-
-					if !recordingStarted {
-						if currentNoiseFloor > noiseFloor*1.5 {
-							recordingStarted = true
-							maxNoiseFloor = currentNoiseFloor
-						}
-					} else {
-						if currentNoiseFloor > maxNoiseFloor {
-							maxNoiseFloor = currentNoiseFloor
-							silenceCount = 0
-						} else if currentNoiseFloor < maxNoiseFloor*0.5 {
-							silenceCount++
-							if silenceCount > 5 { // Stop after 5 consecutive low-noise windows
-								fmt.Fprintf(os.Stderr, "\nNoise level dipped, stopping recording.\n")
-								return audioBuffer
-							}
-						} else {
-							silenceCount = 0
-						}
+			if cfg.abortOnClip {
+				clippedSamples += countClipped(in)
+				if clippedSamples > cfg.clipLimit {
+					return rec, errClipped
+				}
+			}
+
+			if cfg.samples > 0 {
+				// A fixed sample count bypasses speech detection.
+				sampleCount += len(in) / cfg.channels
+				if sampleCount >= cfg.samples {
+					rec.stopReason = "sample count"
+					return rec, nil
+				}
+				continue
+			}
+
+			frames++
+			spin := ' '
+			if !vad.started && !cfg.quiet {
+				spin = spinner[frames%len(spinner)]
+			}
+			st := captureStatus(vad, audioBuffer.Len()/rec.format.blockAlign(), rec.format.sampleRate, false)
+			if ui != nil {
+				ui.draw(st)
+			} else if !cfg.quiet {
+				printStatus(vad, spin, cfg.floorUnits)
+			}
+			if events != nil {
+				events.send(st)
+			}
+			for i := 0; i < len(in); i += cfg.channels {
+				event := vad.updateFrame(in[i : i+cfg.channels])
+				sampleCount++
+				if trace != nil {
+					trace.add(sampleCount, vad, event)
+				}
+				if !vad.ready() {
+					continue
+				}
+				if vad.count == len(vad.window) && cfg.saveCalibration != "" {
+					err := saveCalibration(cfg.saveCalibration, vad.calibration())
+					if err != nil {
+						log.Printf("could not save the calibration: %v", err)
 					}
+				}
 
-					noiseFloor = currentNoiseFloor
+				switch event {
+				case vadStart:
+					rec.ambientFloor = vad.level
+					if cfg.adaptiveFloor {
+						rec.ambientFloor = vad.noiseFloor
+					}
+					rec.markers = append(rec.markers, marker{sampleCount - 1, "speech start"})
+				case vadStop:
+					fmt.Fprintf(os.Stderr, "\nNoise level dipped, stopping recording.\n")
+					rec.markers = append(rec.markers, marker{sampleCount - 1, "speech end"})
+					rec.stopReason = "silence"
+					rec.peakFloor = vad.peak
+					return rec, nil
 				}
 			}
+			rec.peakFloor = vad.peak
 		}
 	}
 }
 
+// spinner animates the status line while waiting for speech.
+var spinner = []rune(`|/-\`)
+
+// printStatus overwrites the status line with the detector's progress,
+// showing the level in the given --floor-units.
+func printStatus(vad *detector, spin rune, units string) {
+	if !vad.ready() {
+		fmt.Fprintf(os.Stderr, "Calibrating %c\r", spin)
+		return
+	}
+	if units == "dbfs" {
+		fmt.Fprintf(os.Stderr, "Current noise floor: %6.1f dBFS %c\r", toDBFS(vad.level), spin)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Current noise floor: %.4f %c\r", vad.level, spin)
+}
+
+// formatFromExtension infers the output format from a file name, falling
+// back to WAV.
+func formatFromExtension(path string) string {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".aiff", ".aif":
+		return "aiff"
+	case ".caf":
+		return "caf"
+	case ".flac", ".opus", ".mp3", ".webm", ".ogg":
+		return ext[1:]
+	}
+	return "wav"
+}
+
+// newHash returns a hash for the named algorithm, or nil if it is not
+// supported.
+func newHash(name string) hash.Hash {
+	switch name {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	case "sha512":
+		return sha512.New()
+	}
+	return nil
+}
+
+func signalName(sig os.Signal) string {
+	switch sig {
+	case syscall.SIGHUP:
+		return "SIGHUP"
+	case syscall.SIGINT:
+		return "SIGINT"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	}
+	return sig.String()
+}
+
 func calculateAverage(window []float64) float64 {
 	sum := 0.0
 	for _, v := range window {
@@ -172,22 +1534,68 @@ func calculateAverage(window []float64) float64 {
 	return sum / float64(len(window))
 }
 
-func generateBeep() []float32 {
-	beepSamples := int(beepDuration * sampleRate)
-	beep := make([]float32, beepSamples)
-
+func generateBeep(duration float64) []float32 {
+	beep := generateTone(beepFrequency, duration)
 	for i := range beep {
-		t := float64(i) / sampleRate
+		t := float64(i) / float64(sampleRate)
 		// Apply a sine wave envelope for a smoother sound
-		envelope := math.Sin(math.Pi * t / beepDuration)
-		beep[i] = float32(math.Sin(2*math.Pi*beepFrequency*t) * envelope * 0.5)
+		beep[i] *= float32(math.Sin(math.Pi * t / duration))
 	}
-
 	return beep
 }
 
-func playBeep(beep []float32) {
-	stream, err := portaudio.OpenDefaultStream(0, 1, sampleRate, len(beep), &beep)
+// generateTone returns duration seconds of a sine at half of full scale.
+func generateTone(frequency, duration float64) []float32 {
+	tone := make([]float32, int(duration*float64(sampleRate)))
+	for i := range tone {
+		t := float64(i) / float64(sampleRate)
+		tone[i] = float32(math.Sin(2*math.Pi*frequency*t) * 0.5)
+	}
+	return tone
+}
+
+// openBeepStream opens a mono output for beep, or, on devices that refuse
+// mono, a stereo one with the beep copied to both channels. A non-zero pan,
+// from -1 for hard left to 1 for hard right, asks for stereo so that the
+// beep can be placed between them; mono-only devices play it centred.
+func openBeepStream(beep []float32, pan float64) (*portaudio.Stream, error) {
+	stream, err := portaudio.OpenDefaultStream(0, 1, float64(sampleRate), len(beep), &beep)
+	if err == nil && pan == 0 {
+		return stream, nil
+	}
+
+	device, derr := portaudio.DefaultOutputDevice()
+	if derr != nil || device.MaxOutputChannels < 2 {
+		return stream, err
+	}
+	if stream != nil {
+		stream.Close()
+	}
+	left, right := float32(min(1, 1-pan)), float32(min(1, 1+pan))
+	stereo := make([]float32, 2*len(beep))
+	for i, v := range beep {
+		stereo[2*i] = v * left
+		stereo[2*i+1] = v * right
+	}
+	return portaudio.OpenDefaultStream(0, 2, float64(sampleRate), len(beep), &stereo)
+}
+
+// playCountdown plays n short ticks, one per second, counting down on
+// stderr.
+func playCountdown(n int, pan float64) {
+	tick := generateBeep(tickDuration)
+	for i := n; i > 0; i-- {
+		fmt.Fprintf(os.Stderr, "%d... ", i)
+		playBeep(tick, pan)
+		time.Sleep(time.Second - time.Duration(tickDuration*float64(time.Second)))
+	}
+	if n > 0 {
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+}
+
+func playBeep(beep []float32, pan float64) {
+	stream, err := openBeepStream(beep, pan)
 	if err != nil {
 		log.Fatal(err)
 	}
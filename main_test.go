@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"path/filepath"
+	"testing"
+)
+
+// The fixtures in testdata are 8 kHz mono 16-bit WAVs:
+//
+//   - speech.wav: 2.5s of near silence, a 0.5s loud burst, then 2.5s of
+//     near silence
+//   - noise.wav: 3s of steady white noise
+//   - clipping.wav: 2.5s of near silence, then 0.5s of a full-scale square
+//     wave
+
+// testConfig parses args as the record subcommand would, away from any
+// config file in the user's home.
+func testConfig(t *testing.T, args ...string) config {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	flag.CommandLine = flag.NewFlagSet("raus", flag.ContinueOnError)
+	return parseFlags(append([]string{"--rate", "8000", "--quiet"}, args...))
+}
+
+// captureFixture runs the detector over a fixture the way --input-file
+// does.
+func captureFixture(t *testing.T, name string, cfg config) (*recording, error) {
+	t.Helper()
+	src, err := openFileSource(filepath.Join("testdata", name), cfg.captureFormat(), cfg.frameSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recordAudioWithDynamicNoiseFloor(src, cfg, nil, nil)
+}
+
+// speechMarkers returns the frames speech started and stopped at, or -1.
+func speechMarkers(rec *recording) (start, stop int) {
+	start, stop = -1, -1
+	for _, m := range rec.markers {
+		switch m.label {
+		case "speech start":
+			start = m.offset
+		case "speech end":
+			stop = m.offset
+		}
+	}
+	return start, stop
+}
+
+// markerTolerance is how many frames a detected boundary may move by, as
+// rounding in the running level can shift it between platforms.
+const markerTolerance = 8
+
+func TestCaptureFixtures(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		args    []string
+		start   int // frame of the speech start marker, or -1
+		stop    int // frame of the speech end marker, or -1
+		frames  int // frames captured
+		written int // frames in the output file
+		reason  string
+		err     error
+	}{
+		{
+			// Without --adaptive-floor speech starts as soon as the
+			// window has filled, and stops once the burst has left it.
+			name:    "speech",
+			file:    "speech.wav",
+			start:   15999,
+			stop:    38005,
+			frames:  38400,
+			written: 38400,
+			reason:  "silence",
+		},
+		{
+			name:    "speech adaptive",
+			file:    "speech.wav",
+			args:    []string{"--adaptive-floor"},
+			start:   20000,
+			stop:    38005,
+			frames:  38400,
+			written: 38400,
+			reason:  "silence",
+		},
+		{
+			name:    "speech only",
+			file:    "speech.wav",
+			args:    []string{"--speech-only"},
+			start:   15999,
+			stop:    38005,
+			frames:  38400,
+			written: 4960,
+			reason:  "silence",
+		},
+		{
+			name:    "speech 8-bit with markers",
+			file:    "speech.wav",
+			args:    []string{"--bit-depth", "8", "--markers"},
+			start:   15999,
+			stop:    38005,
+			frames:  38400,
+			written: 38400,
+			reason:  "silence",
+		},
+		{
+			// Steady noise never falls to half of its level, so the
+			// recording runs to the end of the file.
+			name:    "noise",
+			file:    "noise.wav",
+			start:   15999,
+			stop:    -1,
+			frames:  24000,
+			written: 24000,
+			reason:  "end of input",
+		},
+		{
+			name:    "noise adaptive",
+			file:    "noise.wav",
+			args:    []string{"--adaptive-floor"},
+			start:   -1,
+			stop:    -1,
+			frames:  24000,
+			written: 24000,
+			reason:  "end of input",
+		},
+		{
+			// An odd number of 8-bit samples leaves the data chunk
+			// padded, which the RIFF size has to count.
+			name:    "fixed length 8-bit",
+			file:    "speech.wav",
+			args:    []string{"--samples", "16001", "--bit-depth", "8"},
+			start:   -1,
+			stop:    -1,
+			frames:  16001,
+			written: 16001,
+			reason:  "sample count",
+		},
+		{
+			name: "clipping",
+			file: "clipping.wav",
+			args: []string{"--abort-on-clip"},
+			err:  errClipped,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, tt.args...)
+			rec, err := captureFixture(t, tt.file, cfg)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start, stop := speechMarkers(rec)
+			if !near(start, tt.start) || !near(stop, tt.stop) {
+				t.Errorf("speech from %d to %d, want %d to %d", start, stop, tt.start, tt.stop)
+			}
+			if frames := rec.audio.Len() / rec.format.blockAlign(); frames != tt.frames {
+				t.Errorf("captured %d frames, want %d", frames, tt.frames)
+			}
+			if rec.stopReason != tt.reason {
+				t.Errorf("stopped on %q, want %q", rec.stopReason, tt.reason)
+			}
+
+			var out bytes.Buffer
+			err = writeOutput(&out, "", rec, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, data, err := readWAV(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			format := rec.format
+			format.bitDepth = cfg.bitDepth
+			if header.NumChannels != 1 || header.SampleRate != 8000 || int(header.BitsPerSample) != cfg.bitDepth {
+				t.Errorf("got %d channel(s) at %d Hz, %d-bit", header.NumChannels, header.SampleRate, header.BitsPerSample)
+			}
+			if int(header.BlockAlign) != format.blockAlign() || int(header.ByteRate) != format.byteRate() {
+				t.Errorf("block align %d and byte rate %d, want %d and %d", header.BlockAlign, header.ByteRate, format.blockAlign(), format.byteRate())
+			}
+			if int(header.ChunkSize) != out.Len()-8 {
+				t.Errorf("RIFF size %d, want %d", header.ChunkSize, out.Len()-8)
+			}
+			if written := len(data) / format.blockAlign(); written != tt.written {
+				t.Errorf("wrote %d frames, want %d", written, tt.written)
+			}
+		})
+	}
+}
+
+func near(got, want int) bool {
+	if want < 0 {
+		return got < 0
+	}
+	return got >= want-markerTolerance && got <= want+markerTolerance
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"time"
+)
+
+// metadata is the JSON sidecar written by --metadata. The stream fields are
+// only set for live capture.
+type metadata struct {
+	Start       time.Time  `json:"start"`
+	StreamStart *time.Time `json:"stream_start,omitempty"`
+	StreamClock *float64   `json:"stream_clock,omitempty"` // portaudio stream time at start, in seconds
+	FirstAudio  *time.Time `json:"first_audio,omitempty"`  // stream start plus the offset of the first nonzero frame
+	FirstFrame  *int       `json:"first_audio_frame,omitempty"`
+	SampleRate  int        `json:"sample_rate"`
+	Channels    int        `json:"channels"`
+	Duration    float64    `json:"duration"`
+	StopReason  string     `json:"stop_reason"`
+	SNR         *float64   `json:"snr_db,omitempty"`    // only once speech was detected
+	Overflows   []int      `json:"overflows,omitempty"` // frame offsets at which input was lost
+}
+
+func writeMetadata(path string, rec *recording) error {
+	m := metadata{
+		Start:      rec.start,
+		SampleRate: rec.format.sampleRate,
+		Channels:   rec.format.channels,
+		Duration:   rec.format.duration(rec.audio.Len()).Seconds(),
+		StopReason: rec.stopReason,
+	}
+	if snr := rec.snr(); !math.IsNaN(snr) {
+		m.SNR = &snr
+	}
+	for _, o := range rec.overflows {
+		m.Overflows = append(m.Overflows, o.offset)
+	}
+	if !rec.streamStart.IsZero() {
+		m.StreamStart = &rec.streamStart
+		m.StreamClock = &rec.streamClock
+		if rec.firstAudio >= 0 {
+			offset := time.Duration(rec.firstAudio) * time.Second / time.Duration(rec.format.sampleRate)
+			first := rec.streamStart.Add(offset)
+			m.FirstAudio = &first
+			m.FirstFrame = &rec.firstAudio
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// mixSource sums the audio of several input devices, each with its own
+// gain. Devices that cannot capture at the recording rate, or all of them
+// under --force-device-default-rate, are opened at their own rate and
+// resampled.
+type mixSource struct {
+	inputs     []*streamSource
+	gains      []float64
+	resamplers []*resampler // nil where no resampling is needed
+	pending    [][]int16    // audio read from each input but not yet mixed
+	out        []int16
+	rate       int // every input is resampled to this
+	softClip   bool
+}
+
+// mixBacklog is how many frames of backlog an input may build up, as
+// separate devices drift apart, before its oldest audio is dropped.
+const mixBacklog = 4
+
+// openInput opens the source for live capture: the default input, the one
+// --input-device, or a mix of several. A single device goes through the
+// mixer too when its audio needs a gain or resampling.
+func openInput(cfg config) (audioSource, error) {
+	if len(cfg.inputDevices) > 1 || (len(cfg.inputDevices) == 1 && cfg.inputDevices[0].gainDB != 0) || cfg.forceDefaultRate {
+		m, err := openMixSource(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	var device *portaudio.DeviceInfo
+	var err error
+	if len(cfg.inputDevices) == 1 {
+		device, err = findInputDevice(cfg.inputDevices[0].name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = checkRate(device, cfg.channels, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+	s, err := openStreamSource(cfg, device, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+	// The device may have settled on another rate. Everything timed from
+	// here on, from filters to --arm-timeout, has to go by the real one.
+	sampleRate = s.rate
+	return s, nil
+}
+
+func openMixSource(cfg config) (*mixSource, error) {
+	if cfg.monitor {
+		return nil, errors.New("--monitor works with a single --input-device, without a gain or --force-device-default-rate")
+	}
+
+	inputs := cfg.inputDevices
+	if len(inputs) == 0 {
+		inputs = deviceList{{}} // the default input
+	}
+	m := &mixSource{out: make([]int16, cfg.frameSize*cfg.channels), rate: sampleRate, softClip: cfg.softClip}
+	for _, d := range inputs {
+		var device *portaudio.DeviceInfo
+		var err error
+		if d.name == "" {
+			device, err = portaudio.DefaultInputDevice()
+			err = classifyAudioError(err)
+		} else {
+			device, err = findInputDevice(d.name)
+		}
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+
+		rate := sampleRate
+		if cfg.forceDefaultRate || !supportsRate(device, cfg.channels, sampleRate) {
+			rate = int(device.DefaultSampleRate)
+		}
+		src, err := openStreamSource(cfg, device, rate)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		var rs *resampler
+		if src.rate != sampleRate {
+			rs = newResampler(src.rate, sampleRate, cfg.channels)
+		}
+
+		m.inputs = append(m.inputs, src)
+		m.gains = append(m.gains, dbToGain(d.gainDB))
+		m.resamplers = append(m.resamplers, rs)
+		m.pending = append(m.pending, nil)
+	}
+	return m, nil
+}
+
+func (m *mixSource) Read() ([]int16, error) {
+	n := len(m.out)
+	// An overflow on any input still gives a full frame, and is passed
+	// on once it is mixed so that the capture can note the gap.
+	var overflowed error
+	for i, src := range m.inputs {
+		for len(m.pending[i]) < n {
+			in, err := src.Read()
+			if err == portaudio.InputOverflowed {
+				overflowed = err
+			} else if err != nil {
+				return nil, err
+			}
+			if m.resamplers[i] != nil {
+				in = m.resamplers[i].process(in)
+			}
+			m.pending[i] = append(m.pending[i], in...)
+		}
+		if excess := len(m.pending[i]) - mixBacklog*n; excess > 0 {
+			m.pending[i] = m.pending[i][excess:]
+		}
+	}
+
+	for j := range m.out {
+		sum := 0.0
+		for i := range m.inputs {
+			sum += float64(m.pending[i][j]) * m.gains[i]
+		}
+		m.out[j] = limitSample(sum, m.softClip)
+	}
+	for i := range m.pending {
+		m.pending[i] = append(m.pending[i][:0], m.pending[i][n:]...)
+	}
+	return m.out, overflowed
+}
+
+func (m *mixSource) Rate() int {
+	return m.rate
+}
+
+func (m *mixSource) Close() error {
+	var err error
+	for _, src := range m.inputs {
+		err = errors.Join(err, src.Close())
+	}
+	return err
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// mmapOutput is not available where mmap is not.
+type mmapOutput struct {
+	io.Writer
+}
+
+func createMmapOutput(path string, format pcmFormat, capacity int) (*mmapOutput, error) {
+	return nil, errors.New("--mmap-output is not supported on this platform")
+}
+
+func (m *mmapOutput) Len() int      { return 0 }
+func (m *mmapOutput) Bytes() []byte { return nil }
+func (m *mmapOutput) Close() error  { return nil }
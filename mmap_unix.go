@@ -0,0 +1,109 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"syscall"
+)
+
+// mmapOutput records straight into a WAV file mapped into memory, so that
+// long captures take neither an ever-growing heap buffer nor a write
+// syscall per frame. The file is grown, and remapped, by doubling. The
+// sizes in its header are kept up to date with every write, so a file
+// left behind by a crash is a valid WAV followed by unused space, which
+// Close, or raus repair, trims off.
+type mmapOutput struct {
+	f      *os.File
+	data   []byte
+	header int // bytes of header ahead of the audio
+	size   int // bytes used, including the header
+	format pcmFormat
+}
+
+func createMmapOutput(path string, format pcmFormat, capacity int) (*mmapOutput, error) {
+	format.extensible = format.extensible || format.channels > 2
+	header := &bytes.Buffer{}
+	err := writeStreamingWAVHeader(header, format)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &mmapOutput{f: f, header: header.Len(), size: header.Len(), format: format}
+	err = m.remap(header.Len() + capacity)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	copy(m.data, header.Bytes())
+	m.setSizes()
+	return m, nil
+}
+
+// setSizes writes the RIFF and data chunk sizes of the audio written so
+// far into the header.
+func (m *mmapOutput) setSizes() {
+	dataSize := uint32(m.Len())
+	header := createWAVHeader(m.format, dataSize, nil)
+	binary.LittleEndian.PutUint32(m.data[4:], header.ChunkSize)
+	binary.LittleEndian.PutUint32(m.data[m.header-4:], dataSize)
+}
+
+// remap resizes the file to size bytes and maps all of it.
+func (m *mmapOutput) remap(size int) error {
+	if m.data != nil {
+		err := syscall.Munmap(m.data)
+		m.data = nil
+		if err != nil {
+			return err
+		}
+	}
+	err := m.f.Truncate(int64(size))
+	if err != nil {
+		return err
+	}
+	m.data, err = syscall.Mmap(int(m.f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	return err
+}
+
+func (m *mmapOutput) Write(p []byte) (int, error) {
+	if m.size+len(p) > len(m.data) {
+		err := m.remap(max(2*len(m.data), m.size+len(p)))
+		if err != nil {
+			return 0, err
+		}
+	}
+	m.size += copy(m.data[m.size:], p)
+	m.setSizes()
+	return len(p), nil
+}
+
+// Len returns the number of bytes of audio written.
+func (m *mmapOutput) Len() int {
+	return m.size - m.header
+}
+
+// Bytes returns the audio written so far. It is only valid until the next
+// Write or Close.
+func (m *mmapOutput) Bytes() []byte {
+	return m.data[m.header:m.size]
+}
+
+// Close trims the file to the audio and closes it.
+func (m *mmapOutput) Close() error {
+	err := syscall.Munmap(m.data)
+	m.data = nil
+	if err == nil {
+		err = m.f.Truncate(int64(m.size))
+	}
+	if cerr := m.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
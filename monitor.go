@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// resampledOutput plays captured audio, converted to the rate of the
+// default output device, through a stream of its own. It serves the
+// monitor when the output cannot run at the capture rate, and --replay.
+type resampledOutput struct {
+	stream  *portaudio.Stream
+	buf     []int16
+	pending []int16
+	rs      *resampler
+}
+
+// outputSupportsRate reports whether the default output device can play
+// channels at the capture rate.
+func outputSupportsRate(channels int) bool {
+	out, err := portaudio.DefaultOutputDevice()
+	if err != nil {
+		return true // let opening the stream report the problem
+	}
+	p := portaudio.HighLatencyParameters(nil, out)
+	p.Output.Channels = channels
+	p.SampleRate = float64(sampleRate)
+	return portaudio.IsFormatSupported(p, make([]int16, channels)) == nil
+}
+
+func openResampledOutput(channels, frameSize int) (*resampledOutput, error) {
+	out, err := portaudio.DefaultOutputDevice()
+	if err != nil {
+		return nil, err
+	}
+	rate := int(out.DefaultSampleRate)
+
+	o := &resampledOutput{
+		buf: make([]int16, frameSize*rate/sampleRate*channels),
+		rs:  newResampler(sampleRate, rate, channels),
+	}
+	p := portaudio.HighLatencyParameters(nil, out)
+	p.Output.Channels = channels
+	p.SampleRate = float64(rate)
+	p.FramesPerBuffer = len(o.buf) / channels
+	o.stream, err = portaudio.OpenStream(p, o.buf)
+	if err != nil {
+		return nil, err
+	}
+	err = o.stream.Start()
+	if err != nil {
+		o.stream.Close()
+		return nil, err
+	}
+	return o, nil
+}
+
+// write converts samples to the output rate and plays every full buffer.
+func (o *resampledOutput) write(samples []int16) error {
+	o.pending = append(o.pending, o.rs.process(samples)...)
+	for len(o.pending) >= len(o.buf) {
+		copy(o.buf, o.pending)
+		o.pending = o.pending[:copy(o.pending, o.pending[len(o.buf):])]
+		err := o.stream.Write()
+		if err != nil && err != portaudio.OutputUnderflowed {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *resampledOutput) Close() error {
+	return o.stream.Close()
+}
+
+// replayRecording plays rec back through the default output device.
+func replayRecording(rec *recording, frameSize int) error {
+	channels := rec.format.channels
+	o, err := openResampledOutput(channels, frameSize)
+	if err != nil {
+		return err
+	}
+	defer o.Close()
+
+	fmt.Fprintf(os.Stderr, "Replaying %.1fs...\n", rec.format.duration(rec.audio.Len()).Seconds())
+	samples := decodeSamples(rec.audio.Bytes())
+	step := frameSize * channels
+	for start := 0; start < len(samples); start += step {
+		err = o.write(samples[start:min(start+step, len(samples))])
+		if err != nil {
+			return err
+		}
+	}
+	// Push out the last partial buffer; stopping waits for it to play.
+	err = o.write(make([]int16, len(o.buf)))
+	if err != nil {
+		return err
+	}
+	return o.stream.Stop()
+}
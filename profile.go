@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"log"
+)
+
+// latencyProfile bundles the capture tuning knobs picked by --profile.
+type latencyProfile struct {
+	frameSize int // --frame-size
+	latency   int // --latency, in milliseconds
+}
+
+var latencyProfiles = map[string]latencyProfile{
+	"lowlatency": {frameSize: 128, latency: 10},
+	"balanced":   {frameSize: 512, latency: 50},
+	"robust":     {frameSize: 2048, latency: 200},
+}
+
+// applyProfile loads the config file, then fills in the settings of the
+// --profile block or preset. Flags given on the command line or through
+// the environment are left alone; a profile overrides the config file's
+// top-level settings.
+func applyProfile(c *config) {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	path := c.configPath
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	file, err := loadConfigFile(path, c.configPath != "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	file.apply(file.defaults, set)
+	if c.profile == "" {
+		return
+	}
+	if settings, ok := file.profiles[c.profile]; ok {
+		file.apply(settings, set)
+		return
+	}
+
+	p, ok := latencyProfiles[c.profile]
+	if !ok {
+		log.Fatalf("unknown --profile %q, want lowlatency, balanced, robust or a [profile.%s] block in %s", c.profile, c.profile, path)
+	}
+	if !set["frame-size"] {
+		c.frameSize = p.frameSize
+	}
+	if !set["latency"] {
+		c.latency = p.latency
+	}
+}
@@ -0,0 +1,17 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// raisePriority lowers the process's nice value, which normally needs root.
+func raisePriority() error {
+	err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, -10)
+	if err != nil {
+		return fmt.Errorf("could not raise capture priority: %w", err)
+	}
+	return nil
+}
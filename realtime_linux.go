@@ -0,0 +1,31 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	schedFIFO     = 1
+	realtimeLevel = 10 // low in the SCHED_FIFO range, above normal threads
+)
+
+// raisePriority moves the calling thread to SCHED_FIFO, falling back to a
+// lower nice value. Both need CAP_SYS_NICE (or a suitable RLIMIT_RTPRIO)
+// unless the user is root.
+func raisePriority() error {
+	param := struct{ priority int32 }{realtimeLevel}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, 0, schedFIFO, uintptr(unsafe.Pointer(&param)))
+	if errno == 0 {
+		return nil
+	}
+
+	err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, -10)
+	if err != nil {
+		return fmt.Errorf("could not raise capture priority (%v); grant CAP_SYS_NICE to use --realtime", errno)
+	}
+	return nil
+}
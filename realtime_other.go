@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package main
+
+import "errors"
+
+// raisePriority is not implemented on this platform.
+func raisePriority() error {
+	return errors.New("--realtime is not supported on this platform")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+)
+
+// repairWAV rewrites the RIFF and data chunk sizes of the WAV file at path
+// to match the audio actually on disk, as left by a recording that was
+// killed before its header could be written. A data size that was kept up
+// to date, as --mmap-output does, is trusted when the file holds that much,
+// and whatever follows it is trimmed off. It returns the number of bytes of
+// audio kept.
+func repairWAV(path string) (int64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	fileSize := info.Size()
+
+	var header wavHeader
+	err = binary.Read(f, binary.LittleEndian, &header)
+	if err != nil {
+		return 0, err
+	}
+	if string(header.ChunkID[:]) != "RIFF" || string(header.Format[:]) != "WAVE" || string(header.Subchunk1ID[:]) != "fmt " {
+		return 0, errors.New("not a WAV file")
+	}
+	if header.BlockAlign == 0 {
+		return 0, errors.New("fmt chunk has no block alignment")
+	}
+
+	// Chunks ahead of the data were complete when they were written, so
+	// their sizes can be trusted to find it.
+	pos := int64(20 + header.Subchunk1Size + header.Subchunk1Size%2)
+	var claimed uint32 // the size the data chunk gives
+	for {
+		var chunk struct {
+			ID   [4]byte
+			Size uint32
+		}
+		_, err = f.Seek(pos, io.SeekStart)
+		if err == nil {
+			err = binary.Read(f, binary.LittleEndian, &chunk)
+		}
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = errors.New("missing data chunk")
+			}
+			return 0, err
+		}
+		if string(chunk.ID[:]) == "data" {
+			claimed = chunk.Size
+			break
+		}
+		pos += 8 + int64(chunk.Size+chunk.Size%2)
+	}
+
+	dataStart := pos + 8
+	dataSize := fileSize - dataStart
+	if claimed != math.MaxUint32 && int64(claimed) <= dataSize {
+		dataSize = int64(claimed)
+	}
+	dataSize -= dataSize % int64(header.BlockAlign) // drop a partial frame
+	dataSize = min(dataSize, math.MaxUint32-dataStart)
+
+	err = f.Truncate(dataStart + dataSize)
+	if err == nil && dataSize%2 == 1 {
+		_, err = f.WriteAt([]byte{0}, dataStart+dataSize)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	header.ChunkSize = uint32(dataStart + dataSize + dataSize%2 - 8)
+	_, err = f.Seek(0, io.SeekStart)
+	if err == nil {
+		err = binary.Write(f, binary.LittleEndian, header)
+	}
+	if err == nil {
+		_, err = f.WriteAt(binary.LittleEndian.AppendUint32(nil, uint32(dataSize)), pos+4)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return dataSize, f.Close()
+}
@@ -0,0 +1,61 @@
+package main
+
+import "slices"
+
+// resampler converts interleaved samples between sample rates by linear
+// interpolation, carrying its position across calls so that a stream can
+// be converted one buffer at a time. When lowering the rate, the input is
+// low-passed first so that what lies above the new Nyquist frequency does
+// not alias into the speech band.
+type resampler struct {
+	channels int
+	step     float64 // input frames per output frame
+	pos      float64 // position of the next output frame, from prev
+	prev     []int16 // last input frame of the previous call
+	filters  channelFilters
+}
+
+func newResampler(from, to, channels int) *resampler {
+	r := &resampler{
+		channels: channels,
+		step:     float64(from) / float64(to),
+		prev:     make([]int16, channels),
+	}
+	if to < from {
+		cutoff := 0.45 * float64(to)
+		for range channels {
+			r.filters = append(r.filters, filterChain{newLowPass(cutoff, float64(from)), newLowPass(cutoff, float64(from))})
+		}
+	}
+	return r
+}
+
+// process returns in converted to the output rate.
+func (r *resampler) process(in []int16) []int16 {
+	if r.filters != nil {
+		in = slices.Clone(in)
+		r.filters.apply(in)
+	}
+	n := len(in) / r.channels
+	at := func(frame, ch int) float64 {
+		if frame == 0 {
+			return float64(r.prev[ch])
+		}
+		return float64(in[(frame-1)*r.channels+ch])
+	}
+
+	var out []int16
+	for ; r.pos < float64(n); r.pos += r.step {
+		i := int(r.pos)
+		f := r.pos - float64(i)
+		for ch := range r.channels {
+			out = append(out, clampSample(at(i, ch)*(1-f)+at(i+1, ch)*f))
+		}
+	}
+
+	if n > 0 {
+		r.pos -= float64(n)
+		copy(r.prev, in[(n-1)*r.channels:])
+	}
+	return out
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+// rotateQueue is how many finished segments may wait to be written
+// before recording blocks on the disk.
+const rotateQueue = 4
+
+// keepOpen lets one stream be recorded from repeatedly by ignoring the
+// Close at the end of each recording.
+type keepOpen struct {
+	audioSource
+}
+
+func (keepOpen) Close() error { return nil }
+
+// seamSource holds on to the audio a fixed-length segment read but did
+// not take, and hands it out first to the next one, so that no samples
+// are lost between segments.
+type seamSource struct {
+	audioSource
+	leftover []int16
+}
+
+func (s *seamSource) Read() ([]int16, error) {
+	if len(s.leftover) > 0 {
+		in := s.leftover
+		s.leftover = nil
+		return in, nil
+	}
+	return s.audioSource.Read()
+}
+
+// unread puts samples back to be read again. They are copied, as the
+// stream may reuse its buffer.
+func (s *seamSource) unread(samples []int16) {
+	s.leftover = append(s.leftover[:0], samples...)
+}
+
+// recordRotating records segment after segment from one stream until
+// SIGINT or SIGTERM, keeping only the newest --rotate files in
+// --output-dir. Segments end on silence, or after --rotate-seconds (or
+// --max-file-duration), in which case each picks up exactly where the
+// last one stopped.
+func recordRotating(cfg config) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+
+	src, err := openInput(cfg)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if cfg.rotateSeconds > 0 {
+		cfg.samples = int(cfg.rotateSeconds * float64(src.Rate()))
+	}
+	fmt.Fprintf(os.Stderr, "Recording from %s into rotating segments in %s...\n", inputName(cfg), cfg.outputDir)
+
+	// Segments are written on a goroutine of their own, so that the
+	// stream is read on without a pause while one is saved; otherwise a
+	// slow disk would drop the audio at each seam.
+	segments := make(chan *recording, rotateQueue)
+	written := make(chan error, 1)
+	go func() {
+		written <- writeSegments(segments, cfg)
+	}()
+
+	seam := &seamSource{audioSource: keepOpen{src}}
+	for {
+		rec, err := recordAudioWithDynamicNoiseFloor(seam, cfg, signals, nil)
+		if err != nil {
+			close(segments)
+			<-written
+			return err
+		}
+
+		select {
+		case segments <- rec:
+		case err := <-written:
+			return err
+		}
+
+		if rec.stopSignal == syscall.SIGINT || rec.stopSignal == syscall.SIGTERM {
+			close(segments)
+			return <-written
+		}
+	}
+}
+
+// writeSegments saves each recording it is sent into --output-dir,
+// removing the oldest files beyond --rotate, until segments is closed or a
+// write fails.
+func writeSegments(segments <-chan *recording, cfg config) error {
+	var kept []string
+	for rec := range segments {
+		// Segments can follow each other within a second, so name them to
+		// the millisecond.
+		err := reportRecording(rec, cfg)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(cfg.outputDir, rec.start.Format("raus-20060102-150405.000.")+cfg.format)
+		err = writeFile(path, rec, cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Saved %s\n", path)
+
+		kept = append(kept, path)
+		for len(kept) > cfg.rotate {
+			err := os.Remove(kept[0])
+			if err != nil {
+				log.Print(err)
+			}
+			kept = kept[1:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyBookmark is a no-op where SIGUSR2 does not exist.
+func notifyBookmark(c chan<- os.Signal) {}
+
+// notifyCancel is a no-op where SIGQUIT does not exist.
+func notifyCancel(c chan<- os.Signal) {}
+
+// notifyPause is a no-op where SIGUSR1 does not exist.
+func notifyPause(c chan<- os.Signal) {}
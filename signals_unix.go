@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyBookmark relays SIGUSR2, which drops a bookmark into the current
+// recording.
+func notifyBookmark(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
+
+// notifyCancel relays SIGQUIT, which abandons the current recording
+// without writing it.
+func notifyCancel(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGQUIT)
+}
+
+// notifyPause relays SIGUSR1, which pauses or resumes the recording.
+func notifyPause(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// audioSource delivers captured audio one frame at a time. Read returns
+// io.EOF once the source is exhausted. Rate is the sample rate the audio
+// really has, which a device may have chosen over the one asked for.
+type audioSource interface {
+	Read() ([]int16, error)
+	Rate() int
+	Close() error
+}
+
+// streamSource reads from a portaudio input stream. When monitoring, the
+// stream is duplex and every frame read is played back through a delay
+// line, unless the output cannot run at the capture rate, in which case
+// it gets a separate, resampled stream.
+type streamSource struct {
+	stream   *portaudio.Stream
+	device   *portaudio.DeviceInfo // nil for the default input
+	rate     int
+	channels int
+	in       []int16
+	out      []int16
+	delay    *delayLine
+	monitor  *resampledOutput // set when monitoring through its own stream
+	latency  time.Duration    // suggested latency, or 0 for the device's own
+	mute     int              // samples still to be zeroed after opening
+	started  time.Time        // when the stream started
+	clock    float64          // stream time at that moment, in seconds
+}
+
+// openStreamSource opens device, or the default input if it is nil, at
+// rate.
+func openStreamSource(cfg config, device *portaudio.DeviceInfo, rate int) (*streamSource, error) {
+	in := make([]int16, cfg.frameSize*cfg.channels)
+	s := &streamSource{
+		device:   device,
+		rate:     rate,
+		in:       in,
+		channels: cfg.channels,
+		latency:  time.Duration(cfg.latency) * time.Millisecond,
+	}
+	if cfg.monitor {
+		s.out = make([]int16, len(in))
+		s.delay = newDelayLine(msToSamples(cfg.sidetoneDelay) * cfg.channels)
+		if !outputSupportsRate(cfg.channels) {
+			monitor, err := openResampledOutput(cfg.channels, cfg.frameSize)
+			if err != nil {
+				return nil, fmt.Errorf("could not open the monitor output: %w", err)
+			}
+			s.monitor = monitor
+		}
+	}
+
+	stream, err := s.open(cfg.frameSize)
+	if err != nil {
+		// The device may not accept this buffer size; let portaudio pick
+		// its own and keep reading frames of the requested length.
+		log.Printf("could not open input with --frame-size %d (%v), falling back to the device default", cfg.frameSize, err)
+		stream, err = s.open(portaudio.FramesPerBufferUnspecified)
+	}
+	if err != nil {
+		s.closeMonitor()
+		return nil, classifyAudioError(fmt.Errorf("%w (a power of two such as 256, 512 or 1024 is usually accepted for --frame-size)", err))
+	}
+	s.stream = stream
+
+	err = stream.Start()
+	if err != nil {
+		s.Close()
+		return nil, classifyAudioError(err)
+	}
+	s.started = time.Now()
+	s.clock = stream.Time().Seconds()
+
+	// Some hosts quietly run the device at a rate of their own choosing.
+	// The recording is written at whatever rate the audio really has.
+	actual := int(stream.Info().SampleRate)
+	if actual != rate {
+		if cfg.strictRate {
+			s.Close()
+			return nil, withKind(errUnsupportedFormat, fmt.Errorf("input is running at %d Hz instead of the requested %d Hz", actual, rate))
+		}
+		log.Printf("input is running at %d Hz, not the requested %d Hz; the recording will be written at %d Hz (use --strict-rate to stop instead)", actual, rate, actual)
+		s.rate = actual
+	}
+	s.mute = cfg.muteFirstMs * s.rate / 1000 * cfg.channels
+
+	// Give devices with automatic gain a moment to settle. The stream
+	// clock keeps running, so move the start past the discarded audio.
+	for range cfg.warmupReads {
+		err = stream.Read()
+		if err != nil && err != portaudio.InputOverflowed {
+			s.Close()
+			return nil, err
+		}
+	}
+	s.started = s.started.Add(time.Duration(cfg.warmupReads*cfg.frameSize) * time.Second / time.Duration(s.rate))
+
+	return s, nil
+}
+
+func (s *streamSource) open(framesPerBuffer int) (*portaudio.Stream, error) {
+	in := s.device
+	if in == nil {
+		var err error
+		in, err = portaudio.DefaultInputDevice()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var out *portaudio.DeviceInfo
+	if s.out != nil && s.monitor == nil {
+		var err error
+		out, err = portaudio.DefaultOutputDevice()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p := portaudio.HighLatencyParameters(in, out)
+	p.SampleRate = float64(s.rate)
+	p.FramesPerBuffer = framesPerBuffer
+	p.Input.Channels = s.channels
+	if s.latency > 0 {
+		p.Input.Latency = s.latency
+	}
+	if out == nil {
+		return portaudio.OpenStream(p, s.in)
+	}
+	p.Output.Channels = s.channels
+	if s.latency > 0 {
+		p.Output.Latency = s.latency
+	}
+	return portaudio.OpenStream(p, s.in, s.out)
+}
+
+func (s *streamSource) Read() ([]int16, error) {
+	err := s.stream.Read()
+	if s.mute > 0 {
+		// Swallow the pop many devices make as the stream starts.
+		n := min(s.mute, len(s.in))
+		clear(s.in[:n])
+		s.mute -= n
+	}
+	if err != nil || s.out == nil {
+		return s.in, err
+	}
+
+	s.delay.process(s.in, s.out)
+	if s.monitor != nil {
+		return s.in, s.monitor.write(s.out)
+	}
+	err = s.stream.Write()
+	if err == portaudio.OutputUnderflowed {
+		// A late monitor frame is only an audible glitch; keep recording.
+		err = nil
+	}
+	return s.in, err
+}
+
+func (s *streamSource) Rate() int {
+	return s.rate
+}
+
+func (s *streamSource) Close() error {
+	s.closeMonitor()
+	return s.stream.Close()
+}
+
+func (s *streamSource) closeMonitor() {
+	if s.monitor != nil {
+		s.monitor.Close()
+	}
+}
+
+// delayLine delays a signal by a fixed number of samples.
+type delayLine struct {
+	buf []int16
+	pos int
+}
+
+func newDelayLine(samples int) *delayLine {
+	return &delayLine{buf: make([]int16, samples)}
+}
+
+// process writes in, delayed, to out.
+func (d *delayLine) process(in, out []int16) {
+	if len(d.buf) == 0 {
+		copy(out, in)
+		return
+	}
+
+	for i, s := range in {
+		out[i] = d.buf[d.pos]
+		d.buf[d.pos] = s
+		d.pos = (d.pos + 1) % len(d.buf)
+	}
+}
+
+// fileSource replays the samples of a WAV file as if they were being
+// captured, so that the detector can be exercised offline.
+type fileSource struct {
+	data     *bytes.Reader
+	channels int
+	rate     int
+	in       []int16
+}
+
+func openFileSource(path string, format pcmFormat, frameSize int) (*fileSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header, data, err := readWAV(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	pcm := header.AudioFormat == wavFormatPCM || header.AudioFormat == wavFormatExtensible
+	if !pcm || int(header.BitsPerSample) != format.bitDepth || int(header.NumChannels) != format.channels || int(header.SampleRate) != format.sampleRate {
+		return nil, withKind(errUnsupportedFormat, fmt.Errorf("%s: input must be %d-bit PCM with %d channel(s) at %d Hz", path, format.bitDepth, format.channels, format.sampleRate))
+	}
+
+	return &fileSource{
+		data:     bytes.NewReader(data),
+		channels: format.channels,
+		rate:     format.sampleRate,
+		in:       make([]int16, frameSize*format.channels),
+	}, nil
+}
+
+func (s *fileSource) Rate() int {
+	return s.rate
+}
+
+func (s *fileSource) Read() ([]int16, error) {
+	n := min(s.data.Len()/2, len(s.in))
+	n -= n % s.channels // drop a trailing partial frame
+	if n == 0 {
+		return nil, io.EOF
+	}
+
+	err := binary.Read(s.data, binary.LittleEndian, s.in[:n])
+	return s.in[:n], err
+}
+
+func (s *fileSource) Close() error {
+	return nil
+}
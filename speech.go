@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+const (
+	speechFrameMs = 20 // length of the frames --speech-only classifies
+	speechPadMs   = 60 // audio kept after a speech region, and before it by default
+)
+
+// keepSpeech returns only the stretches of samples classified as speech,
+// joined end to end, each with at least contextMs of the audio that led up
+// to it so that soft onsets are not clipped. Frames count as speech when
+// they are well above the recording's quietest frames, or moderately above
+// them with a high zero-crossing rate, as unvoiced consonants are.
+func keepSpeech(samples []int16, channels, contextMs int) []int16 {
+	step := msToSamples(speechFrameMs) * channels
+	n := (len(samples) + step - 1) / step
+	if n == 0 {
+		return samples
+	}
+
+	energy := make([]float64, n)
+	zcr := make([]float64, n)
+	for f := range n {
+		frame := samples[f*step : min((f+1)*step, len(samples))]
+		energy[f], zcr[f] = frameFeatures(frame, channels)
+	}
+
+	sorted := slices.Clone(energy)
+	slices.Sort(sorted)
+	threshold := math.Max(3*sorted[n/10], 0.002)
+
+	speech := make([]bool, n)
+	for f := range n {
+		speech[f] = energy[f] > threshold || (energy[f] > threshold/2 && zcr[f] > 0.25)
+	}
+
+	before := (contextMs + speechFrameMs - 1) / speechFrameMs
+	after := (speechPadMs + speechFrameMs - 1) / speechFrameMs
+	keep := make([]bool, n)
+	for f, s := range speech {
+		if !s {
+			continue
+		}
+		for k := max(0, f-before); k <= min(n-1, f+after); k++ {
+			keep[k] = true
+		}
+	}
+
+	var out []int16
+	for f, k := range keep {
+		if k {
+			out = append(out, samples[f*step:min((f+1)*step, len(samples))]...)
+		}
+	}
+	return out
+}
+
+// frameFeatures returns the mean amplitude and the zero-crossing rate of
+// a frame of interleaved samples, taking the channels' average.
+func frameFeatures(frame []int16, channels int) (energy, zcr float64) {
+	var crossings, frames int
+	prev := 0.0
+	for i := 0; i+channels <= len(frame); i += channels {
+		mix := 0.0
+		for _, s := range frame[i : i+channels] {
+			mix += float64(s)
+		}
+		energy += math.Abs(mix) / float64(channels) / fullScale
+		if frames > 0 && (mix < 0) != (prev < 0) {
+			crossings++
+		}
+		prev = mix
+		frames++
+	}
+	if frames == 0 {
+		return 0, 0
+	}
+	return energy / float64(frames), float64(crossings) / float64(frames)
+}
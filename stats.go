@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// captureStats accumulates the levels reported by --stats-interval: the
+// RMS since the last report and the peak since the start.
+type captureStats struct {
+	sumSquares float64
+	count      int
+	peak       float64
+}
+
+func (s *captureStats) add(samples []int16) {
+	for _, v := range samples {
+		a := amplitude(v)
+		s.sumSquares += a * a
+		s.peak = max(s.peak, a)
+	}
+	s.count += len(samples)
+}
+
+// report prints the running statistics and starts a new RMS period.
+func (s *captureStats) report(elapsed time.Duration, snr float64) {
+	rms := 0.0
+	if s.count > 0 {
+		rms = math.Sqrt(s.sumSquares / float64(s.count))
+	}
+	estimate := "n/a, no speech yet"
+	if !math.IsNaN(snr) {
+		estimate = fmt.Sprintf("%.1f dB", snr)
+	}
+	fmt.Fprintf(os.Stderr, "\n%s: RMS %.1f dBFS, peak %.1f dBFS, SNR %s\n",
+		elapsed.Truncate(time.Second), toDBFS(rms), toDBFS(s.peak), estimate)
+	s.sumSquares, s.count = 0, 0
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// transcriber streams the recording, as a WAV of unknown length, into the
+// stdin of an external command while it is being captured. The command's
+// output is relayed to stderr.
+type transcriber struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	failed bool
+}
+
+func startTranscriber(command string, format pcmFormat) (*transcriber, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &transcriber{cmd: cmd, stdin: stdin}
+	err = writeStreamingWAVHeader(t.stdin, format)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Write forwards captured audio to the command. If the command stops
+// reading, the recording carries on without it.
+func (t *transcriber) Write(p []byte) (int, error) {
+	if t.failed {
+		return len(p), nil
+	}
+
+	_, err := t.stdin.Write(p)
+	if err != nil {
+		log.Printf("transcribe command stopped reading: %v", err)
+		t.failed = true
+	}
+	return len(p), nil
+}
+
+// Close ends the command's input and waits for it to finish.
+func (t *transcriber) Close() error {
+	t.stdin.Close()
+	return t.cmd.Wait()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// waitForCommand runs the --start-on-cmd command, relaying its output to
+// stderr, and returns once it exits. Only a successful exit lets the
+// recording start; a signal on stop kills the command and cancels it.
+func waitForCommand(command string, stop <-chan os.Signal) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err = <-done:
+		if err != nil {
+			return fmt.Errorf("start command: %w", err)
+		}
+		return nil
+	case <-stop:
+		cmd.Process.Kill()
+		<-done
+		return errCancelled
+	}
+}
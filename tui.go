@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// status is a snapshot of the capture, as shown by --tui.
+type status struct {
+	State   string  // calibrating, armed, recording, stopping or paused
+	Level   float64 // current window level, normalised
+	Floor   float64 // level speech has to rise above
+	Elapsed time.Duration
+}
+
+// captureStatus describes where the detector is, given how many frames
+// have been captured at rate.
+func captureStatus(vad *detector, captured, rate int, paused bool) status {
+	s := status{
+		Level:   vad.level,
+		Floor:   vad.noiseFloor,
+		Elapsed: time.Duration(captured) * time.Second / time.Duration(rate),
+	}
+	switch {
+	case paused:
+		s.State = "paused"
+	case !vad.ready():
+		s.State = "calibrating"
+	case !vad.started:
+		s.State = "armed"
+	case vad.silenceCount > 0:
+		s.State = "stopping"
+	default:
+		s.State = "recording"
+	}
+	return s
+}
+
+// tui draws a live view of the capture on the terminal and reads single
+// key presses from it. The terminal is switched out of line mode with
+// stty for as long as the view is up.
+type tui struct {
+	tty   *os.File
+	saved string // stty settings to restore
+	keys  chan byte
+	title string
+	last  status
+	drawn time.Time
+}
+
+// tuiLines is how many lines a drawing of the view takes.
+const tuiLines = 4
+
+// tuiRefresh is how often the view is redrawn while nothing changes.
+const tuiRefresh = 100 * time.Millisecond
+
+// meterWidth is the number of cells in the level meter, which spans
+// meterRange dB up to full scale.
+const (
+	meterWidth = 40
+	meterRange = 60
+)
+
+func startTUI(title string) (*tui, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.New("--tui needs a terminal")
+	}
+	saved, err := stty(tty, "-g")
+	if err != nil {
+		tty.Close()
+		return nil, err
+	}
+	_, err = stty(tty, "-icanon", "-echo", "min", "1")
+	if err != nil {
+		tty.Close()
+		return nil, err
+	}
+
+	t := &tui{tty: tty, saved: strings.TrimSpace(saved), keys: make(chan byte, 8), title: title}
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			n, err := tty.Read(buf)
+			if err != nil {
+				return
+			}
+			if n == 1 {
+				select {
+				case t.keys <- buf[0]:
+				default: // the capture loop will catch up
+				}
+			}
+		}
+	}()
+
+	fmt.Fprint(tty, "\x1b[?25l") // hide the cursor
+	fmt.Fprint(tty, strings.Repeat("\n", tuiLines))
+	return t, nil
+}
+
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("could not set up the terminal: %w", err)
+	}
+	return string(out), nil
+}
+
+// draw shows s, unless the view was redrawn very recently and nothing but
+// the level has changed.
+func (t *tui) draw(s status) {
+	now := time.Now()
+	if s.State == t.last.State && now.Sub(t.drawn) < tuiRefresh {
+		return
+	}
+	t.last, t.drawn = s, now
+
+	elapsed := s.Elapsed.Truncate(100 * time.Millisecond)
+	lines := []string{
+		t.title,
+		fmt.Sprintf("%-12s %02d:%04.1f", s.State, int(elapsed.Minutes()), math.Mod(elapsed.Seconds(), 60)),
+		fmt.Sprintf("[%s] %6.1f dBFS, floor %6.1f dBFS", meter(s.Level, s.Floor), toDBFS(s.Level), toDBFS(s.Floor)),
+		"q stop   p pause   c cancel",
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "\x1b[%dA", tuiLines)
+	for _, l := range lines {
+		fmt.Fprintf(&b, "\r\x1b[2K%s\n", l)
+	}
+	fmt.Fprint(t.tty, b.String())
+}
+
+// meter renders level as a bar, with the floor marked on it.
+func meter(level, floor float64) string {
+	cell := func(v float64) int {
+		return max(0, min(meterWidth, int((toDBFS(v)+meterRange)/meterRange*meterWidth)))
+	}
+	bar := []byte(strings.Repeat("#", cell(level)) + strings.Repeat(" ", meterWidth-cell(level)))
+	if f := cell(floor); f > 0 && f <= meterWidth {
+		bar[f-1] = '|'
+	}
+	return string(bar)
+}
+
+// Close puts the terminal back the way it was.
+func (t *tui) Close() error {
+	fmt.Fprint(t.tty, "\x1b[?25h")
+	_, err := stty(t.tty, t.saved)
+	t.tty.Close()
+	return err
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// vadEvent is a transition reported by the detector.
+type vadEvent int
+
+const (
+	vadNone vadEvent = iota
+	vadStart
+	vadStop
+)
+
+// minSilenceSamples is how many consecutive quiet samples it takes to stop
+// when no --silence-grace is given.
+const minSilenceSamples = 5
+
+// detector is the speech detector. It keeps a two second running window
+// of sample amplitudes: speech starts when the window's level jumps above
+// the previous one, and stops once the level has stayed under half of its
+// peak for long enough.
+//
+// In adaptive mode the floor is instead calibrated from the first full
+// window and then follows the level slowly while there is no speech, so
+// that a gradual change in the room does not look like the start of it.
+//
+// With a threshold percentile, speech instead starts once the level rises
+// above that percentile of the short-term levels seen while calibrating,
+// which copes with bursty noise better than a fixed step over its mean.
+//
+// With --arm-sustain the level has to stay above the threshold it crossed
+// for that long before speech counts as started, so that a cough or a
+// click does not start a recording.
+//
+// With --channel-gate each channel gets a detector of its own, and speech
+// is under way while any, or all, of them hear it; the parent then only
+// combines their state and shows the loudest channel's levels.
+type detector struct {
+	window       []float64
+	count        int
+	noiseFloor   float64
+	level        float64 // current window level
+	peak         float64 // highest level since speech started
+	started      bool
+	silenceCount int
+	silenceLimit int
+	adaptive     bool
+	decay        float64     // fraction of the gap to the level closed per sample
+	percentile   float64     // 0 for the fixed step over the floor
+	block        int         // samples per short-term level for the percentile
+	threshold    float64     // level to start at, from the percentile
+	sustainLimit int         // samples the level must stay up for to start
+	above        int         // consecutive samples the level has been up for
+	armThreshold float64     // threshold the level crossed, held while it stays up
+	channels     []*detector // per-channel detectors under --channel-gate
+	gateAll      bool        // all channels must hear speech, rather than any
+}
+
+// thresholdBlockMs is the length of the short-term levels that
+// --auto-threshold-percentile ranks.
+const thresholdBlockMs = 50
+
+// newDetector returns a detector for audio captured at rate.
+func newDetector(cfg config, rate int) *detector {
+	d := &detector{
+		window:       make([]float64, windowSeconds*rate),
+		silenceLimit: max(minSilenceSamples, cfg.silenceGrace*rate/1000),
+		started:      cfg.noArm,
+		adaptive:     cfg.adaptiveFloor,
+		decay:        1 / (cfg.floorDecay * float64(rate)),
+		percentile:   cfg.thresholdPercentile,
+		block:        thresholdBlockMs * rate / 1000,
+		sustainLimit: cfg.armSustain * rate / 1000,
+	}
+	if cfg.channelGate != "" && cfg.channels > 1 {
+		single := cfg
+		single.channelGate = ""
+		for range cfg.channels {
+			d.channels = append(d.channels, newDetector(single, rate))
+		}
+		d.gateAll = cfg.channelGate == "all"
+	}
+	return d
+}
+
+// ready reports whether the window has filled and level is meaningful.
+func (d *detector) ready() bool {
+	return d.count >= len(d.window)
+}
+
+// calibration returns what the detector measured over its first window;
+// under --channel-gate, that of its loudest channel.
+func (d *detector) calibration() calibration {
+	return calibration{Floor: d.level, Threshold: d.threshold}
+}
+
+// calibrate skips the calibration window, starting from c instead. The
+// window is filled with the saved floor, which the room's actual level
+// then replaces as it is heard.
+func (d *detector) calibrate(c calibration) {
+	for i := range d.window {
+		d.window[i] = c.Floor
+	}
+	d.count = len(d.window)
+	d.level, d.threshold = c.Floor, c.Threshold
+	if d.adaptive {
+		d.noiseFloor = c.Floor
+	}
+	for _, ch := range d.channels {
+		ch.calibrate(c)
+	}
+}
+
+// speaking reports whether speech has started and not yet stopped.
+func (d *detector) speaking() bool {
+	return d.started && d.silenceCount <= d.silenceLimit
+}
+
+// updateFrame feeds the next frame of interleaved samples to the
+// detector, mixing its channels unless they are gated separately.
+func (d *detector) updateFrame(frame []int16) vadEvent {
+	if d.channels == nil {
+		return d.update(frameAmplitude(frame))
+	}
+	for c, ch := range d.channels {
+		ch.update(amplitude(frame[c]))
+	}
+	d.count++
+	if !d.ready() {
+		return vadNone
+	}
+
+	speaking := d.gateAll
+	quiet := -1 // least silence among the channels that can end the speech
+	d.level, d.noiseFloor, d.peak, d.threshold = 0, 0, 0, 0
+	for _, ch := range d.channels {
+		d.level = max(d.level, ch.level)
+		d.noiseFloor = max(d.noiseFloor, ch.noiseFloor)
+		d.peak = max(d.peak, ch.peak)
+		d.threshold = max(d.threshold, ch.threshold)
+		if d.gateAll {
+			speaking = speaking && ch.speaking()
+			quiet = max(quiet, ch.silenceCount)
+		} else {
+			speaking = speaking || ch.speaking()
+			if ch.started && (quiet < 0 || ch.silenceCount < quiet) {
+				quiet = ch.silenceCount
+			}
+		}
+	}
+	d.silenceCount = max(quiet, 0)
+
+	switch {
+	case speaking && !d.started:
+		d.started = true
+		return vadStart
+	case !speaking && d.started:
+		return vadStop
+	}
+	return vadNone
+}
+
+// update feeds the amplitude of the next sample to the detector.
+func (d *detector) update(amplitude float64) vadEvent {
+	d.window[d.count%len(d.window)] = amplitude
+	d.count++
+	if !d.ready() {
+		return vadNone
+	}
+
+	d.level = calculateAverage(d.window)
+	if d.count == len(d.window) {
+		if d.adaptive {
+			d.noiseFloor = d.level
+		}
+		if d.percentile > 0 {
+			d.threshold = blockPercentile(d.window, d.block, d.percentile)
+		}
+	}
+
+	event := vadNone
+	if !d.started {
+		threshold := d.noiseFloor * 1.5
+		if d.percentile > 0 {
+			threshold = d.threshold
+		}
+		if d.above > 0 {
+			threshold = d.armThreshold
+		}
+		if d.level > threshold {
+			if d.above == 0 {
+				d.armThreshold = threshold
+			}
+			d.above++
+		} else {
+			d.above = 0
+		}
+		if d.above > d.sustainLimit {
+			d.started = true
+			d.peak = d.level
+			event = vadStart
+		}
+	} else {
+		if d.level > d.peak {
+			d.peak = d.level
+			d.silenceCount = 0
+		} else if d.level < d.peak*0.5 {
+			// Pauses shorter than the limit are bridged; any return
+			// to speech level resets the count.
+			d.silenceCount++
+			if d.silenceCount > d.silenceLimit {
+				event = vadStop
+			}
+		} else {
+			d.silenceCount = 0
+		}
+	}
+
+	switch {
+	case !d.adaptive:
+		d.noiseFloor = d.level
+	case !d.started:
+		// Frozen while speech is active.
+		d.noiseFloor += (d.level - d.noiseFloor) * d.decay
+	}
+	return event
+}
+
+// blockPercentile returns the pth percentile of the mean levels of the
+// consecutive blocks of samples.
+func blockPercentile(samples []float64, block int, p float64) float64 {
+	var levels []float64
+	for i := 0; i+block <= len(samples); i += block {
+		levels = append(levels, calculateAverage(samples[i:i+block]))
+	}
+	if len(levels) == 0 {
+		return calculateAverage(samples)
+	}
+	slices.Sort(levels)
+	return levels[int(math.Round(p/100*float64(len(levels)-1)))]
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// benchFixture is the labelled input of vad-bench: a WAV file in the
+// capture format and the spans of it that are speech, in seconds.
+type benchFixture struct {
+	File      string  `json:"file"` // relative to the fixture
+	Tolerance float64 `json:"tolerance"`
+	Regions   []struct {
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+	} `json:"regions"`
+}
+
+// defaultBenchTolerance is how far, in seconds, a detected boundary may be
+// from the labelled one and still count.
+const defaultBenchTolerance = 0.25
+
+// runVADBench runs the detector over a labelled fixture, with the detection
+// flags given, and reports how well it found the speech boundaries.
+func runVADBench(args []string) {
+	cfg := parseFlags(args)
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: raus vad-bench [flags] FIXTURE.json\n")
+		os.Exit(2)
+	}
+	fixturePath := flag.Arg(0)
+
+	data, err := os.ReadFile(fixturePath)
+	if err != nil {
+		fatal(err)
+	}
+	var fixture benchFixture
+	err = json.Unmarshal(data, &fixture)
+	if err != nil {
+		fatal(fmt.Errorf("%s: %w", fixturePath, err))
+	}
+	if fixture.Tolerance == 0 {
+		fixture.Tolerance = defaultBenchTolerance
+	}
+
+	starts, stops, err := detectBoundaries(filepath.Join(filepath.Dir(fixturePath), fixture.File), cfg)
+	if err != nil {
+		fatal(err)
+	}
+
+	var wantStarts, wantStops []float64
+	for _, r := range fixture.Regions {
+		wantStarts = append(wantStarts, r.Start)
+		wantStops = append(wantStops, r.End)
+	}
+	reportBoundaries("start", starts, wantStarts, fixture.Tolerance)
+	reportBoundaries("stop", stops, wantStops, fixture.Tolerance)
+}
+
+// detectBoundaries captures the WAV at path the way consecutive
+// --resume-on-signal takes would, starting afresh after each stop, and
+// returns the times of every start and stop.
+func detectBoundaries(path string, cfg config) (starts, stops []float64, err error) {
+	src, err := openFileSource(path, cfg.captureFormat(), cfg.frameSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer src.Close()
+	cfg.quiet = true
+
+	rate := float64(src.Rate())
+	offset := 0 // frames taken by the takes before this one
+	for {
+		rec, err := recordAudioWithDynamicNoiseFloor(keepOpen{src}, cfg, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, m := range rec.markers {
+			t := float64(offset+m.offset) / rate
+			if m.label == "speech start" {
+				starts = append(starts, t)
+			} else {
+				stops = append(stops, t)
+			}
+		}
+		if rec.stopReason == "end of input" {
+			return starts, stops, nil
+		}
+		offset += rec.audio.Len() / rec.format.blockAlign()
+	}
+}
+
+// reportBoundaries prints the precision and recall of the detected
+// boundaries against the labelled ones, each labelled boundary being
+// matched at most once.
+func reportBoundaries(kind string, got, want []float64, tolerance float64) {
+	used := make([]bool, len(want))
+	var matched int
+	var offset float64
+	for _, g := range got {
+		best := -1
+		for j, w := range want {
+			if used[j] || math.Abs(g-w) > tolerance {
+				continue
+			}
+			if best < 0 || math.Abs(g-w) < math.Abs(g-want[best]) {
+				best = j
+			}
+		}
+		if best >= 0 {
+			used[best] = true
+			matched++
+			offset += g - want[best]
+		}
+	}
+
+	precision, recall := math.NaN(), math.NaN()
+	if len(got) > 0 {
+		precision = float64(matched) / float64(len(got))
+	}
+	if len(want) > 0 {
+		recall = float64(matched) / float64(len(want))
+	}
+	fmt.Printf("%-5s  detected %d, labelled %d, precision %.2f, recall %.2f", kind, len(got), len(want), precision, recall)
+	if matched > 0 {
+		fmt.Printf(", mean offset %+.3fs", offset/float64(matched))
+	}
+	fmt.Println()
+}
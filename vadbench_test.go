@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectBoundaries(t *testing.T) {
+	cfg := testConfig(t, "--adaptive-floor")
+
+	// Two copies of the speech fixture back to back, so that the second
+	// burst is found by a take that starts part way through the file.
+	data, err := os.ReadFile(filepath.Join("testdata", "speech.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, audio, err := readWAV(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := pcmFormat{channels: int(header.NumChannels), sampleRate: int(header.SampleRate), bitDepth: int(header.BitsPerSample)}
+	var twice bytes.Buffer
+	err = writeWAV(&twice, format, append(audio, audio...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "twice.wav")
+	err = os.WriteFile(path, twice.Bytes(), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	starts, stops, err := detectBoundaries(path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStarts := []float64{2.5, 8}
+	wantStops := []float64{4.75, 10.25}
+	if !closeTimes(starts, wantStarts) || !closeTimes(stops, wantStops) {
+		t.Errorf("starts %v and stops %v, want %v and %v", starts, stops, wantStarts, wantStops)
+	}
+}
+
+// closeTimes reports whether got matches want to within markerTolerance
+// frames at the fixtures' rate.
+func closeTimes(got, want []float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > markerTolerance/8000.0 {
+			return false
+		}
+	}
+	return true
+}
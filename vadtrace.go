@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+)
+
+// vadTrace records what the detector saw and decided during a capture,
+// for --vad-trace: its level and floor every envelopeInterval, and a row
+// of its own at each start or stop.
+type vadTrace struct {
+	rate   int
+	step   int // frames between regular rows
+	next   int
+	points []tracePoint
+}
+
+type tracePoint struct {
+	frame        int
+	level, floor float64
+	state        string
+	event        string
+}
+
+func newVADTrace(rate int) *vadTrace {
+	return &vadTrace{rate: rate, step: envelopeInterval * rate / 1000}
+}
+
+// add notes the detector's state after frame, if a row is due or an event
+// happened on it.
+func (t *vadTrace) add(frame int, vad *detector, event vadEvent) {
+	if frame < t.next && event == vadNone {
+		return
+	}
+	p := tracePoint{frame: frame, level: vad.level, floor: vad.noiseFloor}
+	p.state = captureStatus(vad, frame, t.rate, false).State
+	switch event {
+	case vadStart:
+		p.event = "start"
+	case vadStop:
+		p.event = "stop"
+	}
+	t.points = append(t.points, p)
+	if frame >= t.next {
+		t.next = frame + t.step
+	}
+}
+
+// write saves the trace to path as CSV.
+func (t *vadTrace) write(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"time", "level", "floor", "state", "event"})
+	for _, p := range t.points {
+		w.Write([]string{
+			strconv.FormatFloat(float64(p.frame)/float64(t.rate), 'f', 4, 64),
+			strconv.FormatFloat(p.level, 'f', 6, 64),
+			strconv.FormatFloat(p.floor, 'f', 6, 64),
+			p.state,
+			p.event,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
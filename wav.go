@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+// wavHeader is the RIFF header together with the fmt chunk. Any extra
+// chunks and the data chunk follow it.
+type wavHeader struct {
+	ChunkID       [4]byte
+	ChunkSize     uint32
+	Format        [4]byte
+	Subchunk1ID   [4]byte
+	Subchunk1Size uint32
+	AudioFormat   uint16
+	NumChannels   uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+// wavChunk is an additional RIFF chunk written between the fmt and data
+// chunks.
+type wavChunk struct {
+	ID   [4]byte
+	Data []byte
+}
+
+// size returns the number of bytes the chunk occupies in the file,
+// including its header and pad byte.
+func (c wavChunk) size() uint32 {
+	return 8 + uint32(len(c.Data)+len(c.Data)%2)
+}
+
+// pcmFormat describes how samples are laid out in an output file. A bit
+// depth of 32 means IEEE float samples.
+type pcmFormat struct {
+	channels   int
+	sampleRate int
+	bitDepth   int
+	extensible bool // write a WAVE_FORMAT_EXTENSIBLE fmt chunk
+}
+
+// isFloat reports whether samples are stored as 32-bit floats.
+func (f pcmFormat) isFloat() bool {
+	return f.bitDepth == 32
+}
+
+// wavExtension follows the basic fields of a WAVE_FORMAT_EXTENSIBLE fmt
+// chunk.
+type wavExtension struct {
+	Size               uint16
+	ValidBitsPerSample uint16
+	ChannelMask        uint32
+	SubFormat          [16]byte
+}
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+	wavExtensionSize    = 24 // bytes of wavExtension, including Size
+)
+
+// pcmSubFormat is KSDATAFORMAT_SUBTYPE_PCM.
+var pcmSubFormat = [16]byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71}
+
+// floatSubFormat is KSDATAFORMAT_SUBTYPE_IEEE_FLOAT.
+var floatSubFormat = [16]byte{0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71}
+
+// captureFormat returns the format audio is recorded in.
+func (c config) captureFormat() pcmFormat {
+	return pcmFormat{channels: c.channels, sampleRate: sampleRate, bitDepth: 16}
+}
+
+func (f pcmFormat) blockAlign() int {
+	return f.channels * f.bitDepth / 8
+}
+
+func (f pcmFormat) byteRate() int {
+	return f.sampleRate * f.blockAlign()
+}
+
+func createWAVHeader(format pcmFormat, dataSize uint32, extra []wavChunk) wavHeader {
+	chunkSize := 36 + dataSize + dataSize%2 // data is padded to an even length
+	for _, c := range extra {
+		chunkSize += c.size()
+	}
+	fmtSize, audioFormat := uint32(16), uint16(wavFormatPCM)
+	if format.isFloat() {
+		audioFormat = wavFormatFloat
+	}
+	if format.extensible {
+		chunkSize += wavExtensionSize
+		fmtSize += wavExtensionSize
+		audioFormat = wavFormatExtensible
+	}
+
+	return wavHeader{
+		ChunkID:       [4]byte{'R', 'I', 'F', 'F'},
+		ChunkSize:     chunkSize,
+		Format:        [4]byte{'W', 'A', 'V', 'E'},
+		Subchunk1ID:   [4]byte{'f', 'm', 't', ' '},
+		Subchunk1Size: fmtSize,
+		AudioFormat:   audioFormat,
+		NumChannels:   uint16(format.channels),
+		SampleRate:    uint32(format.sampleRate),
+		ByteRate:      uint32(format.byteRate()),
+		BlockAlign:    uint16(format.blockAlign()),
+		BitsPerSample: uint16(format.bitDepth),
+	}
+}
+
+// duration returns how long dataSize bytes of audio in this format last.
+func (f pcmFormat) duration(dataSize int) time.Duration {
+	return time.Duration(float64(dataSize) / float64(f.byteRate()) * float64(time.Second))
+}
+
+// writeWAV writes a complete WAV file holding data, with the extra chunks
+// placed ahead of the data chunk.
+func writeWAV(w io.Writer, format pcmFormat, data []byte, extra ...wavChunk) error {
+	if format.isFloat() {
+		// Files in any format but PCM must give their length in frames.
+		frames := binary.LittleEndian.AppendUint32(nil, uint32(len(data)/format.blockAlign()))
+		extra = append([]wavChunk{{ID: [4]byte{'f', 'a', 'c', 't'}, Data: frames}}, extra...)
+	}
+	header := createWAVHeader(format, uint32(len(data)), extra)
+	err := writeWAVHeader(w, header, format)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range extra {
+		err = writeChunk(w, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeChunk(w, wavChunk{ID: [4]byte{'d', 'a', 't', 'a'}, Data: data})
+}
+
+// writeStreamingWAVHeader writes the header for a WAV whose length is not
+// known up front, as used when audio is streamed while being captured. The
+// size fields are set to their maximum, which most readers take to mean
+// "until end of stream".
+func writeStreamingWAVHeader(w io.Writer, format pcmFormat) error {
+	header := createWAVHeader(format, 0, nil)
+	header.ChunkSize = math.MaxUint32
+	err := writeWAVHeader(w, header, format)
+	if err != nil {
+		return err
+	}
+
+	dataHeader := struct {
+		ID   [4]byte
+		Size uint32
+	}{[4]byte{'d', 'a', 't', 'a'}, math.MaxUint32}
+	return binary.Write(w, binary.LittleEndian, dataHeader)
+}
+
+// writeWAVHeader writes header followed by the fmt extension, if format
+// calls for one.
+func writeWAVHeader(w io.Writer, header wavHeader, format pcmFormat) error {
+	err := binary.Write(w, binary.LittleEndian, header)
+	if err != nil || !format.extensible {
+		return err
+	}
+
+	subFormat := pcmSubFormat
+	if format.isFloat() {
+		subFormat = floatSubFormat
+	}
+	// Speakers are assigned in the standard order: front left, front
+	// right, centre, LFE and so on.
+	return binary.Write(w, binary.LittleEndian, wavExtension{
+		Size:               wavExtensionSize - 2,
+		ValidBitsPerSample: uint16(format.bitDepth),
+		ChannelMask:        uint32(1)<<format.channels - 1,
+		SubFormat:          subFormat,
+	})
+}
+
+func writeChunk(w io.Writer, c wavChunk) error {
+	err := binary.Write(w, binary.LittleEndian, c.ID)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(w, binary.LittleEndian, uint32(len(c.Data)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(c.Data)
+	if err != nil {
+		return err
+	}
+	if len(c.Data)%2 == 1 {
+		_, err = w.Write([]byte{0})
+	}
+	return err
+}
+
+// bextChunk is the Broadcast Wave Format extension chunk (EBU Tech 3285,
+// version 1).
+type bextChunk struct {
+	Description         [256]byte
+	Originator          [32]byte
+	OriginatorReference [32]byte
+	OriginationDate     [10]byte
+	OriginationTime     [8]byte
+	TimeReference       uint64
+	Version             uint16
+	UMID                [64]byte
+	Reserved            [190]byte
+}
+
+// createBextChunk builds a bext chunk stamped with the time of the first
+// sample captured, which for live input is when the stream started.
+// TimeReference counts samples since local midnight, at the recording's
+// rate.
+func createBextChunk(rec *recording) wavChunk {
+	start := rec.streamStart
+	if start.IsZero() {
+		start = rec.start
+	}
+	var bext bextChunk
+	copy(bext.Originator[:], "raus")
+	copy(bext.OriginationDate[:], start.Format("2006-01-02"))
+	copy(bext.OriginationTime[:], start.Format("15:04:05"))
+
+	midnight := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	bext.TimeReference = uint64(start.Sub(midnight).Seconds() * float64(rec.format.sampleRate))
+	bext.Version = 1
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, bext)
+	return wavChunk{ID: [4]byte{'b', 'e', 'x', 't'}, Data: buf.Bytes()}
+}
+
+// readWAV parses a WAV file, returning its format and the contents of its
+// data chunk. Chunks other than fmt and data are skipped.
+func readWAV(r io.Reader) (wavHeader, []byte, error) {
+	var header wavHeader
+	err := binary.Read(r, binary.LittleEndian, &header)
+	if err != nil {
+		return header, nil, err
+	}
+	if string(header.ChunkID[:]) != "RIFF" || string(header.Format[:]) != "WAVE" || string(header.Subchunk1ID[:]) != "fmt " {
+		return header, nil, errors.New("not a WAV file")
+	}
+
+	// Skip any fmt extension beyond the 16 bytes held in wavHeader.
+	_, err = io.CopyN(io.Discard, r, int64(header.Subchunk1Size-16+header.Subchunk1Size%2))
+	if err != nil {
+		return header, nil, err
+	}
+
+	for {
+		var id [4]byte
+		var size uint32
+		err = binary.Read(r, binary.LittleEndian, &id)
+		if err == nil {
+			err = binary.Read(r, binary.LittleEndian, &size)
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("missing data chunk")
+			}
+			return header, nil, err
+		}
+
+		if string(id[:]) == "data" {
+			data, err := io.ReadAll(io.LimitReader(r, int64(size)))
+			return header, data, err
+		}
+
+		_, err = io.CopyN(io.Discard, r, int64(size+size%2))
+		if err != nil {
+			return header, nil, err
+		}
+	}
+}
+
+// marker is a labelled position in the recording, in samples.
+type marker struct {
+	offset int
+	label  string
+}
+
+// cuePoint is a single entry of a cue chunk.
+type cuePoint struct {
+	ID           uint32
+	Position     uint32
+	DataChunkID  [4]byte
+	ChunkStart   uint32
+	BlockStart   uint32
+	SampleOffset uint32
+}
+
+// createCueChunks builds a cue chunk holding markers together with the
+// LIST/adtl chunk carrying their labels.
+func createCueChunks(markers []marker) []wavChunk {
+	cue := &bytes.Buffer{}
+	binary.Write(cue, binary.LittleEndian, uint32(len(markers)))
+
+	labels := &bytes.Buffer{}
+	labels.WriteString("adtl")
+
+	for i, m := range markers {
+		id := uint32(i + 1)
+		binary.Write(cue, binary.LittleEndian, cuePoint{
+			ID:           id,
+			Position:     uint32(m.offset),
+			DataChunkID:  [4]byte{'d', 'a', 't', 'a'},
+			SampleOffset: uint32(m.offset),
+		})
+
+		text := append(binary.LittleEndian.AppendUint32(nil, id), m.label...)
+		writeChunk(labels, wavChunk{ID: [4]byte{'l', 'a', 'b', 'l'}, Data: append(text, 0)})
+	}
+
+	return []wavChunk{
+		{ID: [4]byte{'c', 'u', 'e', ' '}, Data: cue.Bytes()},
+		{ID: [4]byte{'L', 'I', 'S', 'T'}, Data: labels.Bytes()},
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestFloatWAV(t *testing.T) {
+	samples := []int16{0, 1, -1, 1000, -1000, math.MaxInt16, math.MinInt16}
+	for _, channels := range []int{1, 2} {
+		format := pcmFormat{channels: channels, sampleRate: 16000, bitDepth: 32}
+		frames := len(samples) / channels
+		data := encodePCM(samples[:frames*channels], format.bitDepth)
+
+		var out bytes.Buffer
+		err := writeWAV(&out, format, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := out.Bytes()
+
+		var header wavHeader
+		err = binary.Read(bytes.NewReader(file), binary.LittleEndian, &header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.AudioFormat != wavFormatFloat || header.BitsPerSample != 32 {
+			t.Errorf("%d channel(s): format %d with %d bits, want %d with 32", channels, header.AudioFormat, header.BitsPerSample, wavFormatFloat)
+		}
+		if int(header.BlockAlign) != 4*channels || int(header.ByteRate) != 16000*4*channels {
+			t.Errorf("%d channel(s): block align %d and byte rate %d", channels, header.BlockAlign, header.ByteRate)
+		}
+		if int(header.ChunkSize) != len(file)-8 || len(file)%2 != 0 {
+			t.Errorf("%d channel(s): RIFF size %d for a file of %d bytes", channels, header.ChunkSize, len(file))
+		}
+
+		// Non-PCM files need a fact chunk giving their length in frames,
+		// which comes right after the 16-byte fmt chunk.
+		fact := file[binary.Size(header):]
+		if string(fact[:4]) != "fact" || binary.LittleEndian.Uint32(fact[4:]) != 4 {
+			t.Fatalf("%d channel(s): no fact chunk after fmt", channels)
+		}
+		if n := binary.LittleEndian.Uint32(fact[8:]); int(n) != frames {
+			t.Errorf("%d channel(s): fact chunk gives %d frames, want %d", channels, n, frames)
+		}
+
+		_, got, err := readWAV(bytes.NewReader(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(data) {
+			t.Fatalf("%d channel(s): read %d bytes of audio, want %d", channels, len(got), len(data))
+		}
+		for i, s := range samples[:frames*channels] {
+			f := math.Float32frombits(binary.LittleEndian.Uint32(got[4*i:]))
+			if f < -1 || f >= 1 {
+				t.Errorf("sample %d is %v, outside [-1, 1)", s, f)
+			}
+			if back := int16(f * fullScale); back != s {
+				t.Errorf("sample %d came back as %d", s, back)
+			}
+		}
+	}
+}